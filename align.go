@@ -0,0 +1,45 @@
+package mucog
+
+// Alignment aligns the start of the tiles on multiples of boundary bytes (e.g. 4096 for direct
+// I/O), zero padding being inserted before the aligned tiles. In sidecar mode, the offsets in the
+// data file are aligned.
+//
+// If depth is 1, 2 or 3, only the first tile of each group of tiles sharing the values of the
+// depth outermost iterators of the pattern is aligned, as well as the first tile of each pattern
+// of a chain. For example with L>T>I>P and depth 2, the tiles of all the images for a given level
+// and tile position are contiguous, and only the first of them is aligned. Otherwise, all the
+// tiles are aligned.
+func Alignment(boundary uint64, depth int) Option {
+	return func(cog *MultiCOG) {
+		cog.alignment = boundary
+		cog.alignDepth = depth
+	}
+}
+
+// Stats describes the layout of a mucog
+type Stats struct {
+	Size       uint64 // total size of the mucog
+	HeaderSize uint64 // size of the tiff header, the IFDs and the strile arrays
+	Tiles      int    // number of tiles
+	TileSize   uint64 // size of the tiles
+	Padding    uint64 // size of the alignment padding
+	Framing    uint64 // size of the block leaders and trailers
+}
+
+// Stats computes the layout of the mucog that Write would produce, without writing it
+func (cog *MultiCOG) Stats(bigtiff bool, pattern string) (Stats, error) {
+	if err := cog.prepare(bigtiff, pattern); err != nil {
+		return Stats{}, err
+	}
+	st := Stats{
+		Size:       cog.size(bigtiff),
+		HeaderSize: cog.plan.dataOffset,
+		Tiles:      cog.plan.Len(),
+	}
+	for _, size := range cog.plan.size {
+		st.TileSize += uint64(size)
+	}
+	st.Framing = 2 * cog.plan.frame * uint64(st.Tiles)
+	st.Padding = st.Size - st.HeaderSize - st.TileSize - st.Framing
+	return st, nil
+}
@@ -0,0 +1,107 @@
+package mucog
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestAlignment(t *testing.T) {
+	src := testTempFile(t, "src.bin")
+	if _, err := src.Write(testInterlacedData()); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		depth   int
+		aligned func(i int) bool
+	}{
+		{0, func(i int) bool { return true }},
+		// with L>T>I>P, the two images of a tile position form a group
+		{2, func(i int) bool { return i%2 == 0 }},
+	} {
+		cog := testInterlacedCOG(Alignment(64, tc.depth), CopyBufferSize(20))
+		st, err := cog.Stats(false, "L>T>I>P")
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := &bytes.Buffer{}
+		if err := cog.Write(out, false, "L>T>I>P"); err != nil {
+			t.Fatal(err)
+		}
+		if st.Size != uint64(out.Len()) || st.Tiles != 8 || st.TileSize != 64 ||
+			st.Padding != st.Size-st.HeaderSize-st.TileSize || st.Padding == 0 {
+			t.Errorf("depth %d: unexpected stats %+v", tc.depth, st)
+		}
+
+		data := out.Bytes()
+		used := make([]bool, len(data))
+		for i := uint64(0); i < st.HeaderSize; i++ {
+			used[i] = true
+		}
+		for i := 0; i < cog.plan.Len(); i++ {
+			off := cog.plan.offset[i]
+			if tc.aligned(i) != (off%64 == 0) {
+				t.Errorf("depth %d: tile %d at offset %d", tc.depth, i, off)
+			}
+			if !tc.aligned(i) && off != cog.plan.offset[i-1]+8 {
+				t.Errorf("depth %d: tile %d not contiguous with the previous one", tc.depth, i)
+			}
+			ifd, idx := cog.plan.IFD(i)
+			expected := bytes.Repeat([]byte{byte(10*(ifd.OriginalTileOffsets[idx]/32)) + byte(idx)}, 8)
+			if !bytes.Equal(data[off:off+8], expected) {
+				t.Errorf("depth %d: tile %d: %v", tc.depth, i, data[off:off+8])
+			}
+			for k := off; k < off+8; k++ {
+				used[k] = true
+			}
+		}
+		for i, u := range used {
+			if !u && data[i] != 0 {
+				t.Fatalf("depth %d: non zero padding at %d", tc.depth, i)
+			}
+		}
+
+		// zero-copy and virtual outputs are padded the same way
+		dst := testTempFile(t, "dst.tif")
+		// stale content must be overwritten by the padding
+		if _, err := dst.Write(bytes.Repeat([]byte{0xff}, len(data))); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := dst.Seek(0, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		if err := testInterlacedCOGFrom(src, Alignment(64, tc.depth), CopyBufferSize(20)).Write(dst, false, "L>T>I>P"); err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := os.ReadFile(dst.Name()); !bytes.Equal(got, data) {
+			t.Errorf("depth %d: file output differs", tc.depth)
+		}
+		vf, err := testInterlacedCOG(Alignment(64, tc.depth)).VirtualFile(false, "L>T>I>P")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := io.ReadAll(io.NewSectionReader(vf, 0, vf.Size())); !bytes.Equal(got, data) {
+			t.Errorf("depth %d: virtual output differs", tc.depth)
+		}
+	}
+}
+
+func TestAlignmentSidecar(t *testing.T) {
+	hdr, data := &bytes.Buffer{}, &bytes.Buffer{}
+	cog := testInterlacedCOG(Alignment(64, 0))
+	if err := cog.WriteSidecar(hdr, data, false, MUCOGPattern); err != nil {
+		t.Fatal(err)
+	}
+	for _, ifd := range cog.ifds {
+		for _, off := range ifd.NewTileOffsets32 {
+			if off%64 != 0 {
+				t.Errorf("unaligned data offset %d", off)
+			}
+			if !bytes.Equal(data.Bytes()[off:off+8], bytes.Repeat(data.Bytes()[off:off+1], 8)) {
+				t.Errorf("tile at %d: %v", off, data.Bytes()[off:off+8])
+			}
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package mucog
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/google/tiff"
+)
+
+func testTileIFD(subfileType uint32, size uint64, tileOffset uint64) *IFD {
+	return &IFD{
+		SubfileType:               subfileType,
+		ImageWidth:                size,
+		ImageLength:               size,
+		BitsPerSample:             []uint16{8},
+		Compression:               1,
+		PhotometricInterpretation: PhotometricInterpretationMinIsBlack,
+		SamplesPerPixel:           1,
+		PlanarConfiguration:       PlanarConfigurationContig,
+		TileWidth:                 16,
+		TileLength:                16,
+		NewTileOffsets64:          []uint64{tileOffset},
+		TileByteCounts:            []uint32{16 * 16},
+	}
+}
+
+// TestBigTIFFSubIFDOffsets writes a bigtiff whose overview IFDs are located beyond 4GiB
+// (in a sparse file) and checks they can be loaded back.
+func TestBigTIFFSubIFDOffsets(t *testing.T) {
+	f := testTempFile(t, "bigtiff.tif")
+
+	const farOffset = uint64(5 << 30)
+	tile := bytes.Repeat([]byte{42}, 16*16)
+
+	cog := New()
+	top := testTileIFD(0, 16, 0)
+	ovrs := []*IFD{testTileIFD(SubfileTypeReducedImage, 8, 0), testTileIFD(SubfileTypeMask, 16, 0)}
+	top.SubIFDs = ovrs
+	top.SubIFDOffsets = make([]uint64, len(ovrs))
+
+	for _, ifd := range append([]*IFD{top}, ovrs...) {
+		ifd.ntags, ifd.tagsSize, ifd.strileSize, ifd.nplanes = ifd.structure(true)
+	}
+
+	// top level ifd, followed by its tile
+	top.NewTileOffsets64[0] = 16 + top.tagsSize
+	// overviews at farOffset, each followed by its tile
+	off := farOffset
+	for i, ovr := range ovrs {
+		top.SubIFDOffsets[i] = off
+		ovr.NewTileOffsets64[0] = off + ovr.tagsSize
+		off += ovr.tagsSize + uint64(len(tile))
+	}
+
+	if err := cog.writeHeader(f, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeTestIFD(cog, f, top, 16, tile); err != nil {
+		t.Fatal(err)
+	}
+	for i, ovr := range ovrs {
+		if err := writeTestIFD(cog, f, ovr, top.SubIFDOffsets[i], tile); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	tif, err := tiff.Parse(f, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ifds, err := LoadTIFF(tif)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ifds) != 1 || len(ifds[0].SubIFDs) != len(ovrs) {
+		t.Fatalf("unexpected structure: %d ifds", len(ifds))
+	}
+	for i, sifd := range ifds[0].SubIFDs {
+		if sifd.ImageWidth != ovrs[i].ImageWidth || sifd.SubfileType != ovrs[i].SubfileType {
+			t.Errorf("subifd %d: got %dpx/%d, expected %dpx/%d", i,
+				sifd.ImageWidth, sifd.SubfileType, ovrs[i].ImageWidth, ovrs[i].SubfileType)
+		}
+		if sifd.OriginalTileOffsets[0] != ovrs[i].NewTileOffsets64[0] {
+			t.Errorf("subifd %d: tile offset %d, expected %d", i, sifd.OriginalTileOffsets[0], ovrs[i].NewTileOffsets64[0])
+		}
+		buf := make([]byte, len(tile))
+		if _, err := f.ReadAt(buf, int64(sifd.OriginalTileOffsets[0])); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf, tile) {
+			t.Errorf("subifd %d: tile content mismatch", i)
+		}
+	}
+}
+
+func TestClassicTIFFSubIFDOverflow(t *testing.T) {
+	cog := New()
+	ifd := testTileIFD(0, 16, 0)
+	ifd.NewTileOffsets32 = []uint32{0}
+	ifd.NewTileOffsets64 = nil
+	ifd.SubIFDOffsets = []uint64{5 << 30}
+	ifd.ntags, ifd.tagsSize, ifd.strileSize, ifd.nplanes = ifd.structure(false)
+	if err := cog.writeIFD(io.Discard, false, ifd, 8, &TagData{Offset: 8 + ifd.tagsSize}, 0); err == nil {
+		t.Error("expected overflow error")
+	}
+}
+
+// writeTestIFD writes ifd at offset off, followed by its striles and tile data, and checks
+// that the written size matches the one computed by structure()
+func writeTestIFD(cog *MultiCOG, f *os.File, ifd *IFD, off uint64, tile []byte) error {
+	buf := &bytes.Buffer{}
+	striles := &TagData{Offset: off + ifd.tagsSize}
+	if err := cog.writeIFD(buf, true, ifd, off, striles, 0); err != nil {
+		return err
+	}
+	if uint64(buf.Len()) != ifd.tagsSize {
+		return fmt.Errorf("wrote %d bytes of ifd, expected %d", buf.Len(), ifd.tagsSize)
+	}
+	if striles.Len() != 0 {
+		// single tile: offsets and bytecounts must be inlined
+		return fmt.Errorf("wrote %d bytes of striles, expected none", striles.Len())
+	}
+	buf.Write(tile)
+	_, err := f.WriteAt(buf.Bytes(), int64(off))
+	return err
+}
+
+func testLayoutIFD(ox float64, ntiles int, byteCount uint32) *IFD {
+	ifd := &IFD{
+		ImageWidth:                uint64(16 * ntiles),
+		ImageLength:               16,
+		BitsPerSample:             []uint16{8},
+		Compression:               1,
+		PhotometricInterpretation: PhotometricInterpretationMinIsBlack,
+		SamplesPerPixel:           1,
+		PlanarConfiguration:       PlanarConfigurationContig,
+		TileWidth:                 16,
+		TileLength:                16,
+		OriginalTileOffsets:       make([]uint64, ntiles),
+		TileByteCounts:            make([]uint32, ntiles),
+		ModelPixelScaleTag:        []float64{1, 1, 0},
+		ModelTiePointTag:          []float64{0, 0, 0, ox, 0, 0},
+	}
+	for i := range ifd.TileByteCounts {
+		ifd.TileByteCounts[i] = byteCount
+	}
+	return ifd
+}
+
+func TestCompactStriles(t *testing.T) {
+	cog := New()
+	// ~1.2GB of small tiles, followed by two images of 1GiB tiles
+	cog.AppendIFD(testLayoutIFD(0, 20000, 60000))
+	cog.AppendIFD(testLayoutIFD(0, 2, 1<<30))
+	cog.AppendIFD(testLayoutIFD(0, 2, 1<<30))
+
+	if err := cog.computeImageryOffsets(false, "I>L>T>P"); err == nil {
+		t.Error("expected classic tiff overflow")
+	}
+
+	if err := cog.computeImageryOffsets(true, "I>L>T>P"); err != nil {
+		t.Fatal(err)
+	}
+	offsets := [][]uint64{}
+	for i, ifd := range cog.ifds {
+		if (i == 0) != ifd.shortByteCounts {
+			t.Errorf("ifd %d: shortByteCounts=%v", i, ifd.shortByteCounts)
+		}
+		offs := ifd.NewTileOffsets64
+		if len(ifd.NewTileOffsets32) > 0 {
+			offs = make([]uint64, len(ifd.NewTileOffsets32))
+			for j := range offs {
+				offs[j] = uint64(ifd.NewTileOffsets32[j])
+			}
+		}
+		if (i == 2) != (len(ifd.NewTileOffsets64) > 0) {
+			t.Errorf("ifd %d: 64bit offsets=%v", i, len(ifd.NewTileOffsets64) > 0)
+		}
+		offsets = append(offsets, offs)
+	}
+
+	// tiles must be contiguous, starting right after the header
+	next := uint64(16)
+	for _, ifd := range cog.ifds {
+		next += ifd.tagsSize + ifd.strileSize
+	}
+	for i, ifd := range cog.ifds {
+		for j, off := range offsets[i] {
+			if off != next {
+				t.Fatalf("ifd %d tile %d: offset %d, expected %d", i, j, off, next)
+			}
+			next += uint64(ifd.TileByteCounts[j])
+		}
+	}
+
+	if cog.plan.Len() != 20000+2+2 {
+		t.Errorf("plan has %d tiles", cog.plan.Len())
+	}
+	for i := 0; i < cog.plan.Len(); i++ {
+		ifd, idx := cog.plan.IFD(i)
+		if ifd != cog.ifds[0] && ifd != cog.ifds[1] && ifd != cog.ifds[2] {
+			t.Fatalf("plan tile %d: unknown ifd", i)
+		}
+		if i > 0 && cog.plan.offset[i] != cog.plan.offset[i-1]+uint64(cog.plan.size[i-1]) {
+			t.Fatalf("plan tile %d: offset %d not contiguous", i, cog.plan.offset[i])
+		}
+		if cog.plan.size[i] != ifd.TileByteCounts[idx] {
+			t.Fatalf("plan tile %d: size %d", i, cog.plan.size[i])
+		}
+	}
+
+	// layout must be deterministic
+	if err := cog.computeImageryOffsets(true, "I>L>T>P"); err != nil {
+		t.Fatal(err)
+	}
+	for i, ifd := range cog.ifds {
+		if len(ifd.NewTileOffsets64) > 0 {
+			for j := range ifd.NewTileOffsets64 {
+				if ifd.NewTileOffsets64[j] != offsets[i][j] {
+					t.Fatalf("ifd %d tile %d: offset changed", i, j)
+				}
+			}
+		} else {
+			for j := range ifd.NewTileOffsets32 {
+				if uint64(ifd.NewTileOffsets32[j]) != offsets[i][j] {
+					t.Fatalf("ifd %d tile %d: offset changed", i, j)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package mucog
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Compression schemes whose encoded tiles do not depend on the byte order of the file
+var byteOrderIndependentCompressions = map[uint16]bool{
+	6:     true, //Old JPEG
+	7:     true, //JPEG
+	34712: true, //JPEG2000
+	34887: true, //LERC
+	50001: true, //WebP
+	50002: true, //JPEG-XL
+}
+
+// normalizeByteOrder maps any binary.ByteOrder to binary.LittleEndian or binary.BigEndian
+func normalizeByteOrder(enc binary.ByteOrder) binary.ByteOrder {
+	if enc.Uint16([]byte{1, 0}) == 1 {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}
+
+// setupByteOrder checks that the tiles of ifd can be written in a file of byte order enc,
+// and sets the sample size used to byte-swap them when they are copied.
+func (ifd *IFD) setupByteOrder(enc binary.ByteOrder) error {
+	size, err := ifd.byteSwapSize(enc)
+	ifd.swapSize = size
+	return err
+}
+
+// byteSwapSize returns the sample size used to byte-swap the tiles of ifd when they are written
+// in a file of byte order enc, 0 if they are copied as is
+func (ifd *IFD) byteSwapSize(enc binary.ByteOrder) (int, error) {
+	if ifd.r == nil || normalizeByteOrder(ifd.r.ByteOrder()) == normalizeByteOrder(enc) {
+		return 0, nil
+	}
+	bits := uint16(8)
+	for i, b := range ifd.BitsPerSample {
+		if i > 0 && b != bits {
+			return 0, fmt.Errorf("cannot change byte order of mixed bits per sample %v", ifd.BitsPerSample)
+		}
+		bits = b
+	}
+	if len(ifd.SampleFormat) > 0 &&
+		(ifd.SampleFormat[0] == SampleFormatComplexInt || ifd.SampleFormat[0] == SampleFormatComplexIEEEFP) {
+		// each sample is made of a real and an imaginary part
+		bits /= 2
+	}
+	if bits <= 8 ||
+		byteOrderIndependentCompressions[ifd.Compression] ||
+		ifd.Predictor == PredictorFloatingPoint {
+		// floating point predictor data is stored most significant byte first whatever the file byte order
+		return 0, nil
+	}
+	if ifd.Compression > 1 {
+		return 0, fmt.Errorf("cannot change byte order of %d bits samples compressed with %d", bits, ifd.Compression)
+	}
+	switch bits {
+	case 16, 32, 64:
+		return int(bits / 8), nil
+	default:
+		return 0, fmt.Errorf("cannot change byte order of %d bits samples", bits)
+	}
+}
+
+// swapBytes reverses in place the byte order of each sample of size bytes in buf
+func swapBytes(buf []byte, size int) {
+	for s := 0; s+size <= len(buf); s += size {
+		for i, j := s, s+size-1; i < j; i, j = i+1, j-1 {
+			buf[i], buf[j] = buf[j], buf[i]
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package mucog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+
+	"github.com/google/tiff"
+)
+
+func testUInt16IFD(enc binary.ByteOrder, values []uint16) *IFD {
+	data := make([]byte, 2*len(values))
+	for i, v := range values {
+		enc.PutUint16(data[2*i:], v)
+	}
+	ifd := testLayoutIFD(0, 1, uint32(len(data)))
+	ifd.BitsPerSample = []uint16{16}
+	ifd.r = tiff.NewBReader(bytes.NewReader(data), enc)
+	return ifd
+}
+
+func TestByteOrderSwap(t *testing.T) {
+	values := make([]uint16, 16*16)
+	for i := range values {
+		values[i] = uint16(i * 257)
+	}
+
+	f := testTempFile(t, "mm.tif")
+
+	cog := New(ByteOrder(binary.BigEndian))
+	cog.AppendIFD(testUInt16IFD(binary.LittleEndian, values))
+	if _, err := cog.Check(); err != nil || cog.ifds[0].swapSize != 0 {
+		t.Errorf("check set the swap size: %v/%d", err, cog.ifds[0].swapSize)
+	}
+	if err := cog.Write(f, false, MUCOGPattern); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	tif, err := tiff.Parse(f, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tif.Order() != "MM" {
+		t.Errorf("byte order: %s", tif.Order())
+	}
+	ifds, err := LoadTIFF(tif)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, ifds[0].TileByteCounts[0])
+	if _, err := f.ReadAt(buf, int64(ifds[0].OriginalTileOffsets[0])); err != nil {
+		t.Fatal(err)
+	}
+	for i, v := range values {
+		if got := binary.BigEndian.Uint16(buf[2*i:]); got != v {
+			t.Fatalf("sample %d: got %d, expected %d", i, got, v)
+		}
+	}
+}
+
+func TestByteOrderCompatibility(t *testing.T) {
+	for _, tc := range []struct {
+		bits        uint16
+		compression uint16
+		predictor   uint16
+		valid       bool
+	}{
+		{8, 8, 0, true},
+		{16, 1, PredictorHorizontal, true},
+		{16, 7, 0, true},
+		{16, 8, PredictorHorizontal, false},
+		{32, 8, PredictorFloatingPoint, true},
+		{12, 1, 0, false},
+	} {
+		ifd := testUInt16IFD(binary.LittleEndian, make([]uint16, 16*16))
+		ifd.BitsPerSample = []uint16{tc.bits}
+		ifd.Compression = tc.compression
+		ifd.Predictor = tc.predictor
+		err := ifd.setupByteOrder(binary.BigEndian)
+		if (err == nil) != tc.valid {
+			t.Errorf("%d bits, compression %d, predictor %d: got error %v", tc.bits, tc.compression, tc.predictor, err)
+		}
+		if err := ifd.setupByteOrder(binary.LittleEndian); err != nil || ifd.swapSize != 0 {
+			t.Errorf("same byte order: %v/%d", err, ifd.swapSize)
+		}
+	}
+}
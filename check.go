@@ -0,0 +1,305 @@
+package mucog
+
+import (
+	"fmt"
+	"math"
+)
+
+// Issue is an incompatibility of an input image with the first one
+type Issue struct {
+	IFD     int    `json:"ifd"`     // index of the top level ifd of the image
+	Check   string `json:"check"`   // name of the check that failed, e.g. "scale" or "crs"
+	Message string `json:"message"` // description of the incompatibility
+	Fatal   bool   `json:"fatal"`   // the mucog cannot be written
+}
+
+func (is Issue) Error() string {
+	return fmt.Sprintf("ifd %d %s", is.IFD, is.Message)
+}
+
+// Deviation is the difference between the georeferencing of an image and the grid of the mucog
+type Deviation struct {
+	IFD   int     `json:"ifd"`   // index of the top level ifd of the image
+	Scale float64 `json:"scale"` // largest relative difference of the pixel steps with ifd 0
+	X     float64 `json:"x"`     // distance in pixels from the origin of the image to the nearest tile column
+	Y     float64 `json:"y"`     // distance in pixels from the origin of the image to the nearest tile row
+}
+
+// Report lists all the incompatibilities between the input images of a mucog
+type Report struct {
+	Issues []Issue `json:"issues"`
+	// Deviations of the images whose scale and tile size are compatible with ifd 0
+	Deviations []Deviation `json:"deviations"`
+}
+
+// Err returns the first fatal issue of the report, or nil if the mucog can be written
+func (r Report) Err() error {
+	for _, is := range r.Issues {
+		if is.Fatal {
+			return is
+		}
+	}
+	return nil
+}
+
+// Policy is the handling of the inputs whose data type (BitsPerSample, SampleFormat), compression
+// (Compression, Predictor) or PhotometricInterpretation differ from the first one
+type Policy int
+
+const (
+	PolicyStrict Policy = iota // fail
+	PolicyWarn                 // report a non fatal issue
+	PolicyAllow                // ignore
+)
+
+// ParsePolicy parses the name of a policy (strict|warn|allow)
+func ParsePolicy(s string) (Policy, error) {
+	switch s {
+	case "strict":
+		return PolicyStrict, nil
+	case "warn":
+		return PolicyWarn, nil
+	case "allow":
+		return PolicyAllow, nil
+	default:
+		return PolicyStrict, fmt.Errorf("invalid policy %s: must be one of [strict, warn, allow]", s)
+	}
+}
+
+// DataTypePolicy sets the policy applied to inputs whose data type, compression or
+// PhotometricInterpretation differ from the first one (default: PolicyStrict), as readers of
+// the mucog usually expect all its images to be decoded the same way.
+func DataTypePolicy(p Policy) Option {
+	return func(cog *MultiCOG) {
+		cog.dataTypePolicy = p
+	}
+}
+
+// OnCheck sets a function called with the report of the checks run before the mucog is written,
+// when they found no fatal issue. It lets callers handle the non fatal issues, such as the data
+// type differences allowed by PolicyWarn, and the deviations of the images snapped to the grid
+// within the tolerances, which would otherwise be silently ignored.
+func OnCheck(fn func(Report)) Option {
+	return func(cog *MultiCOG) {
+		cog.onCheck = fn
+	}
+}
+
+// Tolerances are the maximum differences allowed between the georeferencing of the images
+type Tolerances struct {
+	Scale     float64 // relative difference of the pixel steps (pixel size and rotation) with ifd 0
+	Alignment float64 // distance in pixels from the origin of an image to the tile grid
+}
+
+// DefaultTolerances returns the tolerances used when none are configured
+func DefaultTolerances() Tolerances {
+	return Tolerances{Scale: 1e-8, Alignment: 0.1}
+}
+
+// WithTolerances sets the tolerances used to check that the images share the same grid
+// (default: DefaultTolerances). Images within tolerance are snapped to the nearest tile.
+func WithTolerances(t Tolerances) Option {
+	return func(cog *MultiCOG) {
+		cog.tolerances = t
+	}
+}
+
+// Check validates all the input images against the first one (georeferencing, crs, scale,
+// tile size, planes, data type, compression, nodata and grid alignment), and reports all
+// the incompatibilities instead of failing on the first one. Data type, compression and
+// PhotometricInterpretation differences are reported according to the DataTypePolicy.
+// The images are left untouched.
+func (cog *MultiCOG) Check() (Report, error) {
+	if len(cog.ifds) == 0 {
+		return Report{}, fmt.Errorf("empty ifds")
+	}
+	return cog.check(), nil
+}
+
+func (cog *MultiCOG) check() Report {
+	r := Report{}
+	add := func(i int, check string, fatal bool, format string, args ...interface{}) {
+		r.Issues = append(r.Issues, Issue{IFD: i, Check: check, Message: fmt.Sprintf(format, args...), Fatal: fatal})
+	}
+
+	gts := make([]geotransform, len(cog.ifds))
+	valid := make([]bool, len(cog.ifds))
+	for i := range cog.ifds {
+		gt, err := cog.imageTransform(i)
+		if err != nil {
+			add(i, "geotransform", true, "geotransform: %v", err)
+			continue
+		}
+		gts[i] = gt
+		valid[i] = true
+	}
+	if !valid[0] {
+		return r
+	}
+	ref := cog.ifds[0]
+	if _, err := gts[0].Inverse(); err != nil {
+		add(0, "geotransform", true, "geotransform: %v", err)
+		return r
+	}
+	crs, crsErr := ref.CRS()
+	if crsErr != nil && !cog.pixelSpace {
+		add(0, "crs", true, "geokeys: %v", crsErr)
+	}
+	rt := ref.rasterType()
+	dscale := make([]float64, len(cog.ifds))
+	tsx, tsy := ref.TileWidth, ref.TileLength
+
+	for i, ifd := range cog.ifds {
+		if !valid[i] {
+			continue
+		}
+		if i > 0 && !cog.pixelSpace {
+			if icrs, err := ifd.CRS(); err != nil {
+				add(i, "crs", true, "geokeys: %v", err)
+			} else if crsErr == nil && icrs != crs {
+				add(i, "crs", true, "crs %s differs from ifd 0 crs %s", icrs, crs)
+			}
+		}
+		if irt := ifd.rasterType(); irt != rt && !cog.pixelSpace && !cog.normalizeRasterType {
+			add(i, "raster type", true, "raster type %s differs from ifd 0 raster type %s",
+				rasterTypeName(irt), rasterTypeName(rt))
+		}
+		gt := gts[i]
+		var oriented bool
+		dscale[i], oriented = gt.basisDeviation(gts[0], cog.tolerances.Scale)
+		if !oriented {
+			// a flipped or rotated grid would place the tiles at the wrong position
+			add(i, "orientation", true, "pixel axes %v are not oriented like ifd 0 pixel axes %v",
+				[]float64{gt[1], gt[2], gt[4], gt[5]}, []float64{gts[0][1], gts[0][2], gts[0][4], gts[0][5]})
+			valid[i] = false
+		} else if dscale[i] > cog.tolerances.Scale {
+			isx, isy := gt.PixelSize()
+			sx, sy := gts[0].PixelSize()
+			add(i, "scale", true, "incompatible scales (x: %.16f/%.16f, y: %.16f/%.16f)", isx, sx, isy, sy)
+			valid[i] = false
+		}
+		if ifd.TileWidth != tsx || ifd.TileLength != tsy {
+			add(i, "tile size", true, "incompatible tile size (sx: %d/%d, sy: %d/%d)",
+				ifd.TileWidth, tsx, ifd.TileLength, tsy)
+			valid[i] = false
+		}
+		if ifd.planeCount() != ref.planeCount() {
+			add(i, "planes", true, "incompatible number of planes (%d/%d)", ifd.planeCount(), ref.planeCount())
+		}
+		if _, err := ifd.byteSwapSize(cog.enc); err != nil {
+			add(i, "byte order", true, "byte order: %v", err)
+		}
+		for s, sifd := range ifd.SubIFDs {
+			if _, err := sifd.byteSwapSize(cog.enc); err != nil {
+				add(i, "byte order", true, "subifd %d byte order: %v", s, err)
+			}
+		}
+		if cog.dataTypePolicy != PolicyAllow {
+			fatal := cog.dataTypePolicy == PolicyStrict
+			if !equalUint16s(ifd.BitsPerSample, ref.BitsPerSample) || !equalUint16s(ifd.SampleFormat, ref.SampleFormat) {
+				add(i, "data type", fatal, "data type (bits %v, format %v) differs from ifd 0 (bits %v, format %v)",
+					ifd.BitsPerSample, ifd.SampleFormat, ref.BitsPerSample, ref.SampleFormat)
+			}
+			if ifd.Compression != ref.Compression || ifd.Predictor != ref.Predictor {
+				add(i, "compression", fatal, "compression %d (predictor %d) differs from ifd 0 compression %d (predictor %d)",
+					ifd.Compression, ifd.Predictor, ref.Compression, ref.Predictor)
+			}
+			if ifd.PhotometricInterpretation != ref.PhotometricInterpretation {
+				add(i, "photometric", fatal, "photometric interpretation %d differs from ifd 0 photometric interpretation %d",
+					ifd.PhotometricInterpretation, ref.PhotometricInterpretation)
+			}
+		}
+		if ifd.NoData != ref.NoData {
+			add(i, "nodata", false, "nodata %q differs from ifd 0 nodata %q", ifd.NoData, ref.NoData)
+		}
+	}
+
+	toPix := gridTransform(gts, valid)
+	for i := range cog.ifds {
+		if !valid[i] {
+			continue
+		}
+		//distance to the nearest tile corner
+		noffx, noffy := toPix.Transform(gts[i].Origin())
+		dx := noffx - math.Round(noffx/float64(tsx))*float64(tsx)
+		dy := noffy - math.Round(noffy/float64(tsy))*float64(tsy)
+		r.Deviations = append(r.Deviations, Deviation{IFD: i, Scale: dscale[i], X: dx, Y: dy})
+		if math.Abs(dx) > cog.tolerances.Alignment || math.Abs(dy) > cog.tolerances.Alignment {
+			add(i, "alignment", true, "invalid grid alignment %f/%f", dx, dy)
+		}
+	}
+	return r
+}
+
+// normalize sets the geotransforms, normalized raster types and byte order of the images of a
+// checked mucog before its structure is computed
+func (cog *MultiCOG) normalize() error {
+	rt := cog.ifds[0].rasterType()
+	for i, ifd := range cog.ifds {
+		gt, err := cog.imageTransform(i)
+		if err != nil {
+			return fmt.Errorf("ifd %d geotransform: %w", i, err)
+		}
+		ifd.gt = gt
+		if cog.normalizeRasterType && !cog.pixelSpace && ifd.rasterType() != rt {
+			ifd.setGeoKeyShort(GTRasterTypeGeoKey, rt)
+			ifd.setGeoreferencing(ifd.gt)
+		}
+		if err := ifd.setupByteOrder(cog.enc); err != nil {
+			return fmt.Errorf("ifd %d byte order: %w", i, err)
+		}
+		for s, sifd := range ifd.SubIFDs {
+			if err := sifd.setupByteOrder(cog.enc); err != nil {
+				return fmt.Errorf("ifd %d subifd %d byte order: %w", i, s, err)
+			}
+		}
+	}
+	return nil
+}
+
+// imageTransform returns the geotransform of image i, or its position in pixel space
+func (cog *MultiCOG) imageTransform(i int) (geotransform, error) {
+	if cog.pixelSpace {
+		return cog.pixelTransform(i), nil
+	}
+	return cog.ifds[i].geotransform()
+}
+
+// gridTransform returns the transform from georeferenced coordinates to the pixels of the mucog,
+// whose origin is the first column and row of the images of geotransforms gts that are valid.
+// It works in the pixel space of image 0, so that south-up and rotated grids are handled like
+// north-up ones.
+func gridTransform(gts []geotransform, valid []bool) geotransform {
+	toPix, _ := gts[0].Inverse()
+	minx, miny := 0.0, 0.0
+	for i, gt := range gts {
+		if valid != nil && !valid[i] {
+			continue
+		}
+		x, y := toPix.Transform(gt.Origin())
+		minx, miny = math.Min(minx, x), math.Min(miny, y)
+	}
+	toPix[0] -= minx
+	toPix[3] -= miny
+	return toPix
+}
+
+// planeCount returns the number of planes of the ifd: 1 if PlanarConfiguration==1, SamplesPerPixel if PlanarConfiguration==2
+func (ifd *IFD) planeCount() uint64 {
+	if ifd.PlanarConfiguration == PlanarConfigurationSeparate {
+		return uint64(ifd.SamplesPerPixel)
+	}
+	return 1
+}
+
+func equalUint16s(a, b []uint16) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
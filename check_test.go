@@ -0,0 +1,249 @@
+package mucog
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCheckReportsAllIssues(t *testing.T) {
+	cog := New()
+	cog.AppendIFD(testLayoutIFD(0, 2, 10))
+	// incompatible scale
+	scaled := testLayoutIFD(32, 2, 10)
+	scaled.ModelPixelScaleTag = []float64{2, 2, 0}
+	cog.AppendIFD(scaled)
+	// incompatible tile size, and another data type
+	tiled := testLayoutIFD(64, 2, 10)
+	tiled.TileWidth, tiled.TileLength = 32, 32
+	tiled.BitsPerSample = []uint16{16}
+	cog.AppendIFD(tiled)
+	// misaligned, and compressed
+	shifted := testLayoutIFD(100.5, 2, 10)
+	shifted.Compression = 8
+	cog.AppendIFD(shifted)
+	// valid
+	cog.AppendIFD(testLayoutIFD(128, 2, 10))
+
+	report, err := cog.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []struct {
+		ifd   int
+		check string
+		fatal bool
+	}{
+		{1, "scale", true},
+		{2, "tile size", true},
+		{2, "data type", true},
+		{3, "compression", true},
+		{3, "alignment", true},
+	}
+	if len(report.Issues) != len(expected) {
+		t.Fatalf("got issues %v", report.Issues)
+	}
+	for i, e := range expected {
+		is := report.Issues[i]
+		if is.IFD != e.ifd || is.Check != e.check || is.Fatal != e.fatal {
+			t.Errorf("issue %d: got %+v, expected %+v", i, is, e)
+		}
+	}
+	if report.Err() != report.Issues[0] {
+		t.Errorf("got error %v", report.Err())
+	}
+
+	// the write fails on the first fatal issue
+	if err := cog.computeStructure(false); err == nil || err.Error() != report.Issues[0].Error() {
+		t.Errorf("got error %v", err)
+	}
+}
+
+func TestCheckWarnings(t *testing.T) {
+	cog := New()
+	cog.AppendIFD(testLayoutIFD(0, 2, 10))
+	nodata := testLayoutIFD(32, 2, 10)
+	nodata.NoData = "0"
+	cog.AppendIFD(nodata)
+
+	report, err := cog.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Issues) != 1 || report.Issues[0].Check != "nodata" || report.Issues[0].Fatal {
+		t.Errorf("got issues %v", report.Issues)
+	}
+	if report.Err() != nil {
+		t.Errorf("got error %v", report.Err())
+	}
+	if err := cog.computeStructure(false); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := New().Check(); err == nil {
+		t.Error("expected error on empty mucog")
+	}
+}
+
+func TestDataTypePolicy(t *testing.T) {
+	newCOG := func(opts ...Option) *MultiCOG {
+		cog := New(opts...)
+		cog.AppendIFD(testLayoutIFD(0, 2, 10))
+		uint16IFD := testLayoutIFD(32, 2, 10)
+		uint16IFD.BitsPerSample = []uint16{16}
+		cog.AppendIFD(uint16IFD)
+		rgb := testLayoutIFD(64, 2, 10)
+		rgb.PhotometricInterpretation = PhotometricInterpretationRGB
+		rgb.Predictor = PredictorHorizontal
+		cog.AppendIFD(rgb)
+		return cog
+	}
+	checks := []string{"data type", "compression", "photometric"}
+
+	for _, policy := range []Policy{PolicyStrict, PolicyWarn, PolicyAllow} {
+		var warnings []Issue
+		cog := newCOG(DataTypePolicy(policy), OnCheck(func(r Report) { warnings = r.Issues }))
+		report, err := cog.Check()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if policy == PolicyAllow {
+			if len(report.Issues) != 0 {
+				t.Errorf("policy %d: got issues %v", policy, report.Issues)
+			}
+		} else {
+			if len(report.Issues) != len(checks) {
+				t.Fatalf("policy %d: got issues %v", policy, report.Issues)
+			}
+			for i, is := range report.Issues {
+				if is.Check != checks[i] || is.Fatal != (policy == PolicyStrict) {
+					t.Errorf("policy %d: issue %d: got %+v", policy, i, is)
+				}
+			}
+		}
+		err = cog.computeStructure(false)
+		if (err != nil) != (policy == PolicyStrict) {
+			t.Errorf("policy %d: got error %v", policy, err)
+		}
+		// the warnings are passed to the caller when writing
+		if policy == PolicyWarn && len(warnings) != len(checks) || policy != PolicyWarn && len(warnings) != 0 {
+			t.Errorf("policy %d: got warnings %v", policy, warnings)
+		}
+	}
+
+	if p, err := ParsePolicy("warn"); err != nil || p != PolicyWarn {
+		t.Errorf("got %v %v", p, err)
+	}
+	if _, err := ParsePolicy("lenient"); err == nil {
+		t.Error("expected invalid policy")
+	}
+}
+
+func TestTolerances(t *testing.T) {
+	newCOG := func(opts ...Option) *MultiCOG {
+		cog := New(opts...)
+		cog.AppendIFD(testLayoutIFD(0, 2, 10))
+		// 0.3 pixel off the grid, with a slightly different pixel size
+		shifted := testLayoutIFD(32.3, 2, 10)
+		shifted.ModelPixelScaleTag = []float64{1 + 1e-6, 1 + 1e-6, 0}
+		cog.AppendIFD(shifted)
+		return cog
+	}
+
+	report, err := newCOG().Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Issues) != 1 || report.Issues[0].Check != "scale" {
+		t.Errorf("got issues %v", report.Issues)
+	}
+
+	cog := newCOG(WithTolerances(Tolerances{Scale: 1e-5, Alignment: 0.1}))
+	if report, _ = cog.Check(); len(report.Issues) != 1 || report.Issues[0].Check != "alignment" {
+		t.Errorf("got issues %v", report.Issues)
+	}
+
+	var written Report
+	cog = newCOG(WithTolerances(Tolerances{Scale: 1e-5, Alignment: 0.5}), OnCheck(func(r Report) { written = r }))
+	if report, _ = cog.Check(); len(report.Issues) != 0 {
+		t.Errorf("got issues %v", report.Issues)
+	}
+	if len(report.Deviations) != 2 {
+		t.Fatalf("got deviations %v", report.Deviations)
+	}
+	if d := report.Deviations[0]; d.IFD != 0 || d.Scale != 0 || d.X != 0 || d.Y != 0 {
+		t.Errorf("got deviation %+v", d)
+	}
+	if d := report.Deviations[1]; d.IFD != 1 || math.Abs(d.Scale-1e-6) > 1e-9 || math.Abs(d.X-0.3) > 1e-6 || d.Y != 0 {
+		t.Errorf("got deviation %+v", d)
+	}
+	// the image is snapped to the nearest tile
+	if err := cog.computeStructure(false); err != nil {
+		t.Fatal(err)
+	}
+	if cog.ifds[1].minx != 2 || cog.ifds[1].miny != 0 {
+		t.Errorf("got offset %d/%d", cog.ifds[1].minx, cog.ifds[1].miny)
+	}
+	// and its deviations are reported when writing
+	if len(written.Deviations) != 2 || written.Deviations[1] != report.Deviations[1] {
+		t.Errorf("got written deviations %v", written.Deviations)
+	}
+}
+
+func testAffineIFD(gt geotransform, ntiles int) *IFD {
+	ifd := testLayoutIFD(0, ntiles, 10)
+	ifd.ModelPixelScaleTag, ifd.ModelTiePointTag = nil, nil
+	ifd.ModelTransformationTag = []float64{
+		gt[1], gt[2], 0, gt[0],
+		gt[4], gt[5], 0, gt[3],
+		0, 0, 0, 0,
+		0, 0, 0, 1,
+	}
+	return ifd
+}
+
+func TestRotatedGrids(t *testing.T) {
+	sin, cos := math.Sincos(math.Pi / 6)
+	rotated := geotransform{1000, 2 * cos, 2 * sin, 500, 2 * sin, -2 * cos}
+	southUp := geotransform{1000, 2, 0, 500, 0, 2}
+	for _, gt := range []geotransform{rotated, southUp} {
+		at := func(x, y float64) geotransform {
+			g := gt
+			g[0], g[3] = gt.Transform(x, y)
+			return g
+		}
+		cog := New()
+		cog.AppendIFD(testAffineIFD(gt, 2))
+		cog.AppendIFD(testAffineIFD(at(32, 16), 2))
+		cog.AppendIFD(testAffineIFD(at(-16, 0), 2))
+		if err := cog.computeStructure(false); err != nil {
+			t.Fatalf("%v: %v", gt, err)
+		}
+		expected := [][2]uint64{{1, 0}, {3, 1}, {0, 0}}
+		for i, ifd := range cog.ifds {
+			if ifd.minx != expected[i][0] || ifd.miny != expected[i][1] {
+				t.Errorf("%v: ifd %d: got offset %d/%d", gt, i, ifd.minx, ifd.miny)
+			}
+		}
+	}
+
+	cog := New()
+	cog.AppendIFD(testLayoutIFD(0, 2, 10))
+	cog.AppendIFD(testAffineIFD(geotransform{32, 1, 0, 0, 0, 1}, 2))
+	cog.AppendIFD(testAffineIFD(geotransform{64, cos, sin, 0, sin, -cos}, 2))
+	cog.AppendIFD(testAffineIFD(geotransform{96, 1, 0, 0, 0, -1}, 2))
+	report, err := cog.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Issues) != 2 {
+		t.Fatalf("got issues %v", report.Issues)
+	}
+	for i, is := range report.Issues {
+		if is.IFD != i+1 || is.Check != "orientation" || !is.Fatal {
+			t.Errorf("got issue %+v", is)
+		}
+	}
+	if err := cog.computeStructure(false); err == nil {
+		t.Error("expected orientation error")
+	}
+}
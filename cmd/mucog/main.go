@@ -2,12 +2,16 @@ package main
 
 import (
 	"context"
+	"encoding/binary"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/airbusgeo/mucog"
@@ -26,40 +30,289 @@ func main() {
 }
 
 func run(ctx context.Context) error {
+	// "mucog check [options] dataset.tif..." reports the incompatibilities between the inputs
+	if len(os.Args) > 1 && os.Args[1] == "check" {
+		return runCheck(os.Args[2:])
+	}
 	outfile := flag.String("output", "out.tif", "destination file")
 	sbigtiff := flag.String("bigtiff", "auto", "force bigtiff (yes|no|auto)")
 	pattern := flag.String("pattern", mucog.MUCOGPattern, "pattern to use for data interlacing (default: \""+mucog.MUCOGPattern+"\")")
+	keepTags := flag.String("keeptags", "", "comma separated list of the extra tags to keep (default: all)")
+	dropTags := flag.String("droptags", "", "comma separated list of the extra tags to drop")
+	shardSpec := flag.String("shards", "", "split the output in several files, e.g. \"L=0;L=1:\" (see mucog.Shards). Shards are written to output_N.tif, and described in output.json")
+	sidecar := flag.Bool("sidecar", false, "write the IFDs and strile arrays to output.hdr, and the tiles only to output (see mucog.WriteSidecar)")
+	align := flag.Uint64("align", 0, "align the tiles on multiples of this number of bytes, e.g. 4096 (default: no alignment)")
+	alignDepth := flag.Int("aligndepth", 0, "only align the groups of tiles sharing the values of this number of outermost iterators of the pattern (1-3, default: align all tiles)")
+	ghost := flag.Bool("ghost", false, "write a GDAL ghost area, and wrap the tiles with a block leader and trailer")
+	dryRun := flag.Bool("dryrun", false, "compute and print the layout of the output without writing it")
+	resume := flag.Bool("resume", false, "record progress in a checkpoint file next to the output, and resume an interrupted write")
+	in := addInputFlags(flag.CommandLine)
 	flag.Parse()
 
 	args := flag.Args()
 	if len(args) < 1 {
-		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [options] dataset.tif [dataset_2.tif...]\nOptions:\n", filepath.Base(os.Args[0]))
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [options] dataset.tif [dataset_2.tif...]\n       %s check [options] dataset.tif [dataset_2.tif...]\nOptions:\n",
+			filepath.Base(os.Args[0]), filepath.Base(os.Args[0]))
 		flag.PrintDefaults()
 		return fmt.Errorf("")
 	}
 
-	totalSize := int64(0)
-	multicog := mucog.New()
+	opts, loadOpts, err := in.options()
+	if err != nil {
+		return err
+	}
+	if *keepTags != "" {
+		tags, err := parseTags(*keepTags)
+		if err != nil {
+			return fmt.Errorf("invalid keeptags option: %w", err)
+		}
+		opts = append(opts, mucog.KeepTags(tags...))
+	}
+	if *dropTags != "" {
+		tags, err := parseTags(*dropTags)
+		if err != nil {
+			return fmt.Errorf("invalid droptags option: %w", err)
+		}
+		opts = append(opts, mucog.DropTags(tags...))
+	}
+	if *ghost {
+		opts = append(opts, mucog.GhostArea())
+	}
+	if *align > 1 {
+		opts = append(opts, mucog.Alignment(*align, *alignDepth))
+	}
+	// input file of each top level ifd
+	var inputs []string
+	opts = append(opts, mucog.OnCheck(func(report mucog.Report) {
+		printReport(os.Stderr, report, inputs)
+	}))
+
+	multicog := mucog.New(opts...)
+	inputs, files, totalSize, err := loadInputs(multicog, args, loadOpts)
+	defer closeAll(files)
+	if err != nil {
+		return err
+	}
+
+	bigtiff := totalSize > int64(^uint32(0))
+	switch *sbigtiff {
+	case "yes":
+		bigtiff = true
+	case "no":
+		bigtiff = false
+	case "auto":
+	default:
+		return fmt.Errorf("invalid bigtiff option")
+	}
+
+	if *dryRun {
+		st, err := multicog.Stats(bigtiff, *pattern)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("size: %d\nheader: %d\ntiles: %d (%d bytes)\nblock leaders/trailers: %d\npadding: %d (%.2f%%)\n",
+			st.Size, st.HeaderSize, st.Tiles, st.TileSize, st.Framing, st.Padding, 100*float64(st.Padding)/float64(st.Size))
+		return nil
+	}
+
+	if *shardSpec != "" {
+		if *resume || *sidecar {
+			return fmt.Errorf("resume and sidecar are not supported for sharded outputs")
+		}
+		return writeShards(multicog, *outfile, *shardSpec, bigtiff, *pattern)
+	}
+
+	if *sidecar {
+		if *resume {
+			return fmt.Errorf("resume is not supported for sidecar outputs")
+		}
+		return writeSidecar(multicog, *outfile, bigtiff, *pattern)
+	}
+
+	if *resume {
+		out, err := os.OpenFile(*outfile, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return fmt.Errorf("open %s: %w", *outfile, err)
+		}
+		err = multicog.WriteResumable(out, *outfile+".checkpoint", bigtiff, *pattern)
+		if err != nil {
+			out.Close()
+			return fmt.Errorf("resume: %w", err)
+		}
+		if err = out.Close(); err != nil {
+			return fmt.Errorf("close %s: %w", *outfile, err)
+		}
+		return nil
+	}
+
+	out, err := os.Create(*outfile)
+	if err != nil {
+		return fmt.Errorf("create %s: %w", *outfile, err)
+	}
+
+	err = multicog.Write(out, bigtiff, *pattern)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = out.Close()
+	if err != nil {
+		return fmt.Errorf("close %s: %w", *outfile, err)
+	}
+	return nil
+}
+
+// runCheck runs the check subcommand, which reports all the incompatibilities between the inputs
+func runCheck(arguments []string) error {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	jsonReport := fs.Bool("json", false, "print the report as json")
+	in := addInputFlags(fs)
+	fs.Parse(arguments)
+
+	args := fs.Args()
+	if len(args) < 1 {
+		fmt.Fprintf(fs.Output(), "Usage: %s check [options] dataset.tif [dataset_2.tif...]\nOptions:\n", filepath.Base(os.Args[0]))
+		fs.PrintDefaults()
+		return fmt.Errorf("")
+	}
+	opts, loadOpts, err := in.options()
+	if err != nil {
+		return err
+	}
+	multicog := mucog.New(opts...)
+	inputs, files, _, err := loadInputs(multicog, args, loadOpts)
+	defer closeAll(files)
+	if err != nil {
+		return err
+	}
+
+	report, err := multicog.Check()
+	if err != nil {
+		return err
+	}
+	if *jsonReport {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return fmt.Errorf("encode report: %w", err)
+		}
+		fmt.Println(string(data))
+	} else {
+		printReport(os.Stdout, report, inputs)
+	}
+	if report.Err() != nil {
+		return fmt.Errorf("inputs cannot be assembled into a mucog")
+	}
+	return nil
+}
 
+// printReport prints the deviations and issues of a check report, inputs being the input file of
+// each top level ifd
+func printReport(w io.Writer, report mucog.Report, inputs []string) {
+	for _, dev := range report.Deviations {
+		if dev.Scale != 0 || dev.X != 0 || dev.Y != 0 {
+			fmt.Fprintf(w, "deviation: %s (ifd %d): scale %g, x %f px, y %f px\n", inputs[dev.IFD], dev.IFD, dev.Scale, dev.X, dev.Y)
+		}
+	}
+	for _, issue := range report.Issues {
+		level := "warning"
+		if issue.Fatal {
+			level = "error"
+		}
+		fmt.Fprintf(w, "%s: %s (ifd %d): %s: %s\n", level, inputs[issue.IFD], issue.IFD, issue.Check, issue.Message)
+	}
+}
+
+// inputFlags are the options shared by the write and check commands, which control how the
+// inputs are loaded and validated
+type inputFlags struct {
+	byteorder           *string
+	normalizeRasterType *bool
+	dataTypePolicy      *string
+	scaleTolerance      *float64
+	alignTolerance      *float64
+	pixelSpace          *bool
+	pixelOffsets        *string
+	retile              *string
+}
+
+func addInputFlags(fs *flag.FlagSet) *inputFlags {
+	return &inputFlags{
+		byteorder:           fs.String("byteorder", "little", "byte order of the output file (little|big)"),
+		normalizeRasterType: fs.Bool("normalizerastertype", false, "rewrite the georeferencing of inputs whose raster type (PixelIsArea/PixelIsPoint) differs from the first one, instead of failing"),
+		dataTypePolicy:      fs.String("datatypepolicy", "strict", "handling of inputs whose data type, compression or photometric interpretation differ from the first one (strict|warn|allow)"),
+		scaleTolerance:      fs.Float64("scaletolerance", mucog.DefaultTolerances().Scale, "maximum relative difference of the pixel sizes of the inputs"),
+		alignTolerance:      fs.Float64("aligntolerance", mucog.DefaultTolerances().Alignment, "maximum distance in pixels from the origin of an input to the tile grid"),
+		pixelSpace:          fs.Bool("pixelspace", false, "align the inputs in pixel space instead of using their georeferencing, e.g. for non georeferenced or RPC only inputs"),
+		pixelOffsets:        fs.String("pixeloffsets", "", "pixel offsets of the inputs in pixel space, as a \";\" separated list of column,row (default: all inputs at 0,0)"),
+		retile:              fs.String("retile", "", "convert stripped inputs into tiles of this size, e.g. 256 or 512x256 (default: reject stripped inputs)"),
+	}
+}
+
+// options returns the mucog and load options of the parsed flags
+func (in *inputFlags) options() ([]mucog.Option, []mucog.LoadOption, error) {
+	var enc binary.ByteOrder
+	switch *in.byteorder {
+	case "little":
+		enc = binary.LittleEndian
+	case "big":
+		enc = binary.BigEndian
+	default:
+		return nil, nil, fmt.Errorf("invalid byteorder option")
+	}
+	opts := []mucog.Option{mucog.ByteOrder(enc)}
+
+	policy, err := mucog.ParsePolicy(*in.dataTypePolicy)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid datatypepolicy option: %w", err)
+	}
+	opts = append(opts, mucog.DataTypePolicy(policy))
+	opts = append(opts, mucog.WithTolerances(mucog.Tolerances{Scale: *in.scaleTolerance, Alignment: *in.alignTolerance}))
+	if *in.pixelSpace || *in.pixelOffsets != "" {
+		offsets, err := parsePixelOffsets(*in.pixelOffsets)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid pixeloffsets option: %w", err)
+		}
+		opts = append(opts, mucog.PixelSpace(offsets...))
+	}
+	if *in.normalizeRasterType {
+		opts = append(opts, mucog.NormalizeRasterType())
+	}
+
+	loadOpts := []mucog.LoadOption{}
+	if *in.retile != "" {
+		width, length, err := parseTileSize(*in.retile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid retile option: %w", err)
+		}
+		loadOpts = append(loadOpts, mucog.RetileStrips(width, length))
+	}
+	return opts, loadOpts, nil
+}
+
+// loadInputs appends the images of the input files to multicog, and returns the input file of
+// each top level ifd, the opened files and their total size
+func loadInputs(multicog *mucog.MultiCOG, args []string, loadOpts []mucog.LoadOption) ([]string, []*os.File, int64, error) {
+	var inputs []string
+	var files []*os.File
+	totalSize := int64(0)
 	for _, input := range args {
 		topFile, err := os.Open(input)
 		if err != nil {
-			return fmt.Errorf("open %s: %w", args[0], err)
+			return nil, files, 0, fmt.Errorf("open %s: %w", input, err)
 		}
-		defer topFile.Close()
+		files = append(files, topFile)
 		st, err := topFile.Stat()
 		if err != nil {
-			return fmt.Errorf("stat %s: %w", args[0], err)
+			return nil, files, 0, fmt.Errorf("stat %s: %w", input, err)
 		}
 		totalSize += st.Size()
 
 		tif, err := tiff.Parse(topFile, nil, nil)
 		if err != nil {
-			log.Fatal(fmt.Errorf("parse %s: %w", input, err))
+			return nil, files, 0, fmt.Errorf("parse %s: %w", input, err)
 		}
-		tifmifds, err := mucog.LoadTIFF(tif)
+		tifmifds, err := mucog.LoadTIFF(tif, append(loadOpts, mucog.LoadSource(topFile))...)
 		if err != nil {
-			log.Fatal(fmt.Errorf("load %s: %w", input, err))
+			return nil, files, 0, fmt.Errorf("load %s: %w", input, err)
 		}
 		if len(tifmifds) == 1 && tifmifds[0].DocumentName == "" {
 			tifmifds[0].DocumentName = path.Base(input)
@@ -68,32 +321,123 @@ func run(ctx context.Context) error {
 		}
 		for _, mifd := range tifmifds {
 			multicog.AppendIFD(mifd)
+			inputs = append(inputs, input)
 		}
 	}
+	return inputs, files, totalSize, nil
+}
 
-	bigtiff := totalSize > int64(^uint32(0))
-	switch *sbigtiff {
-	case "yes":
-		bigtiff = true
-	case "no":
-		bigtiff = false
-	case "auto":
-	default:
-		return fmt.Errorf("invalid bigtiff option")
+func closeAll(files []*os.File) {
+	for _, f := range files {
+		f.Close()
 	}
+}
 
-	out, err := os.Create(*outfile)
+func writeSidecar(multicog *mucog.MultiCOG, outfile string, bigtiff bool, pattern string) error {
+	hdr, err := os.Create(outfile + ".hdr")
 	if err != nil {
-		return fmt.Errorf("create %s: %w", *outfile, err)
+		return fmt.Errorf("create %s.hdr: %w", outfile, err)
+	}
+	defer hdr.Close()
+	data, err := os.Create(outfile)
+	if err != nil {
+		return fmt.Errorf("create %s: %w", outfile, err)
+	}
+	defer data.Close()
+	if err := multicog.WriteSidecar(hdr, data, bigtiff, pattern); err != nil {
+		return err
+	}
+	if err := hdr.Close(); err != nil {
+		return fmt.Errorf("close %s.hdr: %w", outfile, err)
 	}
+	if err := data.Close(); err != nil {
+		return fmt.Errorf("close %s: %w", outfile, err)
+	}
+	return nil
+}
 
-	err = multicog.Write(out, bigtiff, *pattern)
+func writeShards(multicog *mucog.MultiCOG, outfile, spec string, bigtiff bool, pattern string) error {
+	shards, err := multicog.Shards(spec)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-	err = out.Close()
+	base := strings.TrimSuffix(outfile, filepath.Ext(outfile))
+	index := mucog.ShardIndex{}
+	for i, shard := range shards {
+		name := fmt.Sprintf("%s_%d%s", base, i, filepath.Ext(outfile))
+		out, err := os.Create(name)
+		if err != nil {
+			return fmt.Errorf("create %s: %w", name, err)
+		}
+		if err := shard.Write(out, bigtiff, pattern); err != nil {
+			out.Close()
+			return fmt.Errorf("write %s: %w", name, err)
+		}
+		if err := out.Close(); err != nil {
+			return fmt.Errorf("close %s: %w", name, err)
+		}
+		index.Shards = append(index.Shards, mucog.ShardIndexEntry{
+			File:   filepath.Base(name),
+			Images: shard.Images,
+			Levels: shard.Levels,
+		})
+	}
+	data, err := json.MarshalIndent(index, "", "  ")
 	if err != nil {
-		return fmt.Errorf("close %s: %w", *outfile, err)
+		return fmt.Errorf("encode shard index: %w", err)
+	}
+	if err := os.WriteFile(base+".json", data, 0644); err != nil {
+		return fmt.Errorf("write shard index: %w", err)
 	}
 	return nil
 }
+
+func parseTags(s string) ([]uint16, error) {
+	var tags []uint16
+	for _, tag := range strings.Split(s, ",") {
+		id, err := strconv.ParseUint(strings.TrimSpace(tag), 10, 16)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, uint16(id))
+	}
+	return tags, nil
+}
+
+func parsePixelOffsets(s string) ([][2]uint64, error) {
+	var offsets [][2]uint64
+	if s == "" {
+		return offsets, nil
+	}
+	for _, offset := range strings.Split(s, ";") {
+		xy := strings.Split(offset, ",")
+		if len(xy) != 2 {
+			return nil, fmt.Errorf("%s is not column,row", offset)
+		}
+		x, err := strconv.ParseUint(strings.TrimSpace(xy[0]), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		y, err := strconv.ParseUint(strings.TrimSpace(xy[1]), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		offsets = append(offsets, [2]uint64{x, y})
+	}
+	return offsets, nil
+}
+
+func parseTileSize(s string) (uint16, uint16, error) {
+	wl := strings.SplitN(s, "x", 2)
+	width, err := strconv.ParseUint(wl[0], 10, 16)
+	if err != nil {
+		return 0, 0, err
+	}
+	length := width
+	if len(wl) == 2 {
+		if length, err = strconv.ParseUint(wl[1], 10, 16); err != nil {
+			return 0, 0, err
+		}
+	}
+	return uint16(width), uint16(length), nil
+}
@@ -0,0 +1,59 @@
+// mucogjoin reassembles a single file mucog from the header and data files written in sidecar
+// mode (mucog -sidecar).
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/airbusgeo/mucog"
+)
+
+func main() {
+	if err := run(); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	outfile := flag.String("output", "out.tif", "destination file")
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) != 1 && len(args) != 2 {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [options] data.tif [data.tif.hdr]\nOptions:\n", filepath.Base(os.Args[0]))
+		flag.PrintDefaults()
+		return fmt.Errorf("")
+	}
+	dataName, hdrName := args[0], args[0]+".hdr"
+	if len(args) == 2 {
+		hdrName = args[1]
+	}
+
+	hdr, err := os.Open(hdrName)
+	if err != nil {
+		return fmt.Errorf("open %s: %w", hdrName, err)
+	}
+	defer hdr.Close()
+	data, err := os.Open(dataName)
+	if err != nil {
+		return fmt.Errorf("open %s: %w", dataName, err)
+	}
+	defer data.Close()
+
+	out, err := os.Create(*outfile)
+	if err != nil {
+		return fmt.Errorf("create %s: %w", *outfile, err)
+	}
+	if err := mucog.JoinSidecar(out, hdr, data); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("close %s: %w", *outfile, err)
+	}
+	return nil
+}
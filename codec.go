@@ -0,0 +1,257 @@
+package mucog
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// codec decodes and encodes the strips and tiles of a compression scheme
+type codec struct {
+	decode func(src []byte) ([]byte, error)
+	encode func(src []byte) ([]byte, error)
+}
+
+// codecs are the compression schemes whose strips can be re-tiled
+var codecs = map[uint16]codec{
+	1:     {decode: func(src []byte) ([]byte, error) { return src, nil }, encode: func(src []byte) ([]byte, error) { return src, nil }},
+	5:     {decode: lzwDecode, encode: lzwEncode},         //LZW
+	8:     {decode: deflateDecode, encode: deflateEncode}, //Adobe Deflate
+	32773: {decode: packBitsDecode, encode: packBitsEncode},
+	32946: {decode: deflateDecode, encode: deflateEncode}, //Deflate
+}
+
+func deflateDecode(src []byte) ([]byte, error) {
+	zr, err := zlib.NewReader(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return io.ReadAll(zr)
+}
+
+func deflateEncode(src []byte) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	zw := zlib.NewWriter(buf)
+	if _, err := zw.Write(src); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func packBitsDecode(src []byte) ([]byte, error) {
+	var dst []byte
+	for i := 0; i < len(src); {
+		n := int(int8(src[i]))
+		i++
+		switch {
+		case n >= 0:
+			if i+n+1 > len(src) {
+				return nil, fmt.Errorf("packbits: truncated literal run")
+			}
+			dst = append(dst, src[i:i+n+1]...)
+			i += n + 1
+		case n != -128:
+			if i >= len(src) {
+				return nil, fmt.Errorf("packbits: truncated replicate run")
+			}
+			for k := 0; k < 1-n; k++ {
+				dst = append(dst, src[i])
+			}
+			i++
+		}
+	}
+	return dst, nil
+}
+
+func packBitsEncode(src []byte) ([]byte, error) {
+	var dst []byte
+	literal := func(lit []byte) {
+		for len(lit) > 0 {
+			n := len(lit)
+			if n > 128 {
+				n = 128
+			}
+			dst = append(dst, byte(n-1))
+			dst = append(dst, lit[:n]...)
+			lit = lit[n:]
+		}
+	}
+	start := 0 //start of the pending literal run
+	for i := 0; i < len(src); {
+		run := 1
+		for i+run < len(src) && run < 128 && src[i+run] == src[i] {
+			run++
+		}
+		if run < 3 {
+			i += run
+			continue
+		}
+		literal(src[start:i])
+		dst = append(dst, byte(int8(1-run)), src[i])
+		i += run
+		start = i
+	}
+	literal(src[start:])
+	return dst, nil
+}
+
+const (
+	lzwClear = 256
+	lzwEOI   = 257
+	lzwFirst = 258
+	lzwMax   = 4096
+)
+
+// lzwDecode decodes TIFF LZW data, whose code width increases one code early compared to the
+// GIF variant of compress/lzw
+func lzwDecode(src []byte) ([]byte, error) {
+	var dst []byte
+	table := make([][]byte, lzwMax)
+	next, width := lzwFirst, 9
+	acc, nbits, pos := uint32(0), 0, 0
+	var prev []byte
+	for {
+		for nbits < width && pos < len(src) {
+			acc = acc<<8 | uint32(src[pos])
+			pos++
+			nbits += 8
+		}
+		if nbits < width {
+			// tolerate a missing end of information code
+			return dst, nil
+		}
+		code := int(acc>>uint(nbits-width)) & (1<<uint(width) - 1)
+		nbits -= width
+		if code == lzwClear {
+			next, width, prev = lzwFirst, 9, nil
+			continue
+		}
+		if code == lzwEOI {
+			return dst, nil
+		}
+		var entry []byte
+		switch {
+		case code < lzwClear:
+			entry = []byte{byte(code)}
+		case code < next && table[code] != nil:
+			entry = table[code]
+		case code == next && prev != nil:
+			entry = append(prev[:len(prev):len(prev)], prev[0])
+		default:
+			return nil, fmt.Errorf("lzw: invalid code %d", code)
+		}
+		dst = append(dst, entry...)
+		if prev != nil && next < lzwMax {
+			table[next] = append(prev[:len(prev):len(prev)], entry[0])
+			next++
+		}
+		prev = entry
+		if next >= 1<<uint(width)-1 && width < 12 {
+			width++
+		}
+	}
+}
+
+// lzwEncode encodes data with TIFF LZW, as done by libtiff
+func lzwEncode(src []byte) ([]byte, error) {
+	var dst []byte
+	acc, nbits, width := uint32(0), 0, 9
+	put := func(code int) {
+		acc = acc<<uint(width) | uint32(code)
+		nbits += width
+		for nbits >= 8 {
+			dst = append(dst, byte(acc>>uint(nbits-8)))
+			nbits -= 8
+		}
+	}
+	put(lzwClear)
+	if len(src) == 0 {
+		put(lzwEOI)
+	} else {
+		table := map[int]int{}
+		next := lzwFirst
+		prefix := int(src[0])
+		for _, c := range src[1:] {
+			key := prefix<<8 | int(c)
+			if code, ok := table[key]; ok {
+				prefix = code
+				continue
+			}
+			put(prefix)
+			prefix = int(c)
+			table[key] = next
+			if next++; next == lzwMax-2 {
+				// table is full
+				put(lzwClear)
+				table, next, width = map[int]int{}, lzwFirst, 9
+			} else if next >= 1<<uint(width) {
+				width++
+			}
+		}
+		put(prefix)
+		// the decoder adds an entry when reading the last code
+		if next++; next == lzwMax-2 {
+			put(lzwClear)
+			width = 9
+		} else if next >= 1<<uint(width) {
+			width++
+		}
+		put(lzwEOI)
+	}
+	if nbits > 0 {
+		dst = append(dst, byte(acc<<uint(8-nbits)))
+	}
+	return dst, nil
+}
+
+// horizontalPredictor applies (or reverts if decode is set) the horizontal differencing predictor to
+// rows of rowSize bytes, made of samples of size bytes interleaved by stride samples
+func horizontalPredictor(data []byte, rowSize, size, stride int, enc binary.ByteOrder, decode bool) error {
+	if size != 1 && size != 2 && size != 4 && size != 8 {
+		return fmt.Errorf("cannot apply predictor to %d bits samples", 8*size)
+	}
+	get := func(b []byte) uint64 {
+		switch size {
+		case 1:
+			return uint64(b[0])
+		case 2:
+			return uint64(enc.Uint16(b))
+		case 4:
+			return uint64(enc.Uint32(b))
+		default:
+			return enc.Uint64(b)
+		}
+	}
+	set := func(b []byte, v uint64) {
+		switch size {
+		case 1:
+			b[0] = byte(v)
+		case 2:
+			enc.PutUint16(b, uint16(v))
+		case 4:
+			enc.PutUint32(b, uint32(v))
+		default:
+			enc.PutUint64(b, v)
+		}
+	}
+	step := size * stride
+	for row := 0; row+rowSize <= len(data); row += rowSize {
+		r := data[row : row+rowSize]
+		if decode {
+			for i := step; i+size <= len(r); i += size {
+				set(r[i:], get(r[i:])+get(r[i-step:]))
+			}
+		} else {
+			for i := (len(r)/size - 1) * size; i >= step; i -= size {
+				set(r[i:], get(r[i:])-get(r[i-step:]))
+			}
+		}
+	}
+	return nil
+}
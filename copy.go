@@ -0,0 +1,352 @@
+package mucog
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/google/tiff"
+)
+
+// DefaultCopyBufferSize is the default amount of tile data read ahead when copying tiles
+const DefaultCopyBufferSize = 32 * 1024 * 1024
+
+// CopyBufferSize sets the amount of tile data that is read ahead when copying the tiles to the
+// output. Within this window, reads are sorted by source file and offset, and contiguous reads
+// are coalesced. The tiles are still written in the order of the tile plan.
+func CopyBufferSize(size int) Option {
+	return func(cog *MultiCOG) {
+		cog.copyBufferSize = size
+	}
+}
+
+// sourceRead is a read of one or more contiguous tiles of a source file
+type sourceRead struct {
+	r      tiff.BReader
+	rid    int    // order of r, for sorting
+	offset uint64 // offset in the source file
+	size   uint64
+	tiles  []int // plan indices of the tiles, in source order
+}
+
+// copyTiles copies the tiles of the plan to out
+func (cog *MultiCOG) copyTiles(out io.Writer) error {
+	if f, ok := out.(*os.File); ok && cog.plan.hasFileSource() && positional(f) {
+		if base, err := f.Seek(0, io.SeekCurrent); err == nil {
+			return cog.copyTilesAt(f, base, 0, nil)
+		}
+	}
+	readers := map[tiff.BReader]int{}
+	var buf, scratch []byte
+	pos := cog.plan.dataOffset
+	for start := 0; start < cog.plan.Len(); {
+		end, total := cog.plan.batch(start, cog.copyBufferSize)
+		bstart := cog.plan.blockStart(start)
+		if lead := bstart - pos; lead > 0 {
+			if _, err := out.Write(make([]byte, lead)); err != nil {
+				return fmt.Errorf("write padding: %w", err)
+			}
+		}
+		if uint64(cap(buf)) < total {
+			buf = make([]byte, total)
+		}
+		buf = buf[:total]
+		cog.plan.padding(start+1, end, func(off, size uint64) error {
+			zero(buf[off-bstart:][:size])
+			return nil
+		})
+
+		reads := cog.plan.sourceReads(start, end, readers)
+		for _, rd := range reads {
+			if uint64(cap(scratch)) < rd.size {
+				scratch = make([]byte, rd.size)
+			}
+			data := scratch[:rd.size]
+			n, err := rd.r.ReadAt(data, int64(rd.offset))
+			if n < len(data) {
+				if err == nil {
+					err = io.ErrUnexpectedEOF
+				}
+				return fmt.Errorf("read %d from %d: %w", rd.size, rd.offset, err)
+			}
+			// scatter the tiles at their position in the output batch
+			pos := uint64(0)
+			for _, t := range rd.tiles {
+				ifd, _ := cog.plan.IFD(t)
+				size := uint64(cog.plan.size[t])
+				dst := buf[cog.plan.offset[t]-bstart:][:size]
+				copy(dst, data[pos:pos+size])
+				if ifd.swapSize > 0 {
+					swapBytes(dst, ifd.swapSize)
+				}
+				if cog.plan.frame > 0 {
+					leader, trailer := blockFrame(cog.plan.size[t], dst)
+					copy(buf[cog.plan.blockStart(t)-bstart:], leader[:])
+					copy(buf[cog.plan.offset[t]+size-bstart:], trailer[:])
+				}
+				pos += size
+			}
+		}
+		if _, err := out.Write(buf); err != nil {
+			return fmt.Errorf("write %d tiles: %w", end-start, err)
+		}
+		pos = bstart + total
+		start = end
+	}
+	return nil
+}
+
+// copyTilesAt copies the tiles of the plan to out, the tile data (i.e. the first tile of the plan
+// or the padding that precedes it) being written at offset base. Tiles from source files are
+// transferred with zero-copy syscalls where possible. Copy begins at tile from of the plan, and
+// done (if not nil) is called each time the tiles up to end have been copied.
+func (cog *MultiCOG) copyTilesAt(out *os.File, base int64, from int, done func(end int) error) error {
+	if cog.plan.Len() == 0 {
+		return nil
+	}
+	first := cog.plan.dataOffset
+	zc := &zeroCopier{}
+	readers := map[tiff.BReader]int{}
+	var scratch []byte
+	writeFrame := func(t int, tail []byte) error {
+		leader, trailer := blockFrame(cog.plan.size[t], tail)
+		if _, err := out.WriteAt(leader[:], base+int64(cog.plan.blockStart(t)-first)); err != nil {
+			return fmt.Errorf("write block leader: %w", err)
+		}
+		if _, err := out.WriteAt(trailer[:], base+int64(cog.plan.offset[t]+uint64(cog.plan.size[t])-first)); err != nil {
+			return fmt.Errorf("write block trailer: %w", err)
+		}
+		return nil
+	}
+	for start := from; start < cog.plan.Len(); {
+		end, _ := cog.plan.batch(start, cog.copyBufferSize)
+		// explicitly write the padding, as out may hold a previous content
+		err := cog.plan.padding(start, end, func(off, size uint64) error {
+			_, err := out.WriteAt(make([]byte, size), base+int64(off-first))
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("write padding: %w", err)
+		}
+		for _, rd := range cog.plan.sourceReads(start, end, readers) {
+			srcIFD, _ := cog.plan.IFD(rd.tiles[0])
+			src, isFile := srcIFD.src.(*os.File)
+			for _, t := range rd.tiles {
+				if ifd, _ := cog.plan.IFD(t); ifd.swapSize > 0 {
+					isFile = false
+				}
+			}
+			if isFile {
+				// copy each run of tiles that are contiguous in both the source and the output
+				srcOff := rd.offset
+				for k := 0; k < len(rd.tiles); {
+					dst := cog.plan.offset[rd.tiles[k]]
+					n := uint64(0)
+					for ; k < len(rd.tiles) && cog.plan.offset[rd.tiles[k]] == dst+n; k++ {
+						n += uint64(cog.plan.size[rd.tiles[k]])
+					}
+					if err := zc.copyRange(out, base+int64(dst-first), src, int64(srcOff), int64(n)); err != nil {
+						return fmt.Errorf("copy %d from %d: %w", n, srcOff, err)
+					}
+					srcOff += n
+				}
+				if cog.plan.frame > 0 {
+					srcOff = rd.offset
+					for _, t := range rd.tiles {
+						size := uint64(cog.plan.size[t])
+						var buf [blockFrameSize]byte
+						tail := buf[:]
+						if size < blockFrameSize {
+							tail = tail[:size]
+						}
+						if _, err := src.ReadAt(tail, int64(srcOff+size)-int64(len(tail))); err != nil {
+							return fmt.Errorf("read block trailer: %w", err)
+						}
+						if err := writeFrame(t, tail); err != nil {
+							return err
+						}
+						srcOff += size
+					}
+				}
+				continue
+			}
+			if uint64(cap(scratch)) < rd.size {
+				scratch = make([]byte, rd.size)
+			}
+			data := scratch[:rd.size]
+			n, err := rd.r.ReadAt(data, int64(rd.offset))
+			if n < len(data) {
+				if err == nil {
+					err = io.ErrUnexpectedEOF
+				}
+				return fmt.Errorf("read %d from %d: %w", rd.size, rd.offset, err)
+			}
+			pos := uint64(0)
+			for _, t := range rd.tiles {
+				ifd, _ := cog.plan.IFD(t)
+				size := uint64(cog.plan.size[t])
+				tile := data[pos : pos+size]
+				if ifd.swapSize > 0 {
+					swapBytes(tile, ifd.swapSize)
+				}
+				if _, err := out.WriteAt(tile, base+int64(cog.plan.offset[t]-first)); err != nil {
+					return fmt.Errorf("write tile: %w", err)
+				}
+				if cog.plan.frame > 0 {
+					if err := writeFrame(t, tile); err != nil {
+						return err
+					}
+				}
+				pos += size
+			}
+		}
+		if done != nil {
+			if err := done(end); err != nil {
+				return err
+			}
+		}
+		start = end
+	}
+	_, err := out.Seek(base+int64(cog.plan.blockEnd(cog.plan.Len()-1)-first), io.SeekStart)
+	return err
+}
+
+// zeroCopier transfers byte ranges between files, remembering which syscalls are not supported
+type zeroCopier struct {
+	noCopyFileRange bool
+	noSendfile      bool
+	buf             []byte
+}
+
+// genericCopy copies n bytes from src at srcOff to dst at dstOff through a user space buffer
+func (zc *zeroCopier) genericCopy(dst *os.File, dstOff int64, src *os.File, srcOff int64, n int64) error {
+	if zc.buf == nil {
+		zc.buf = make([]byte, 1024*1024)
+	}
+	for n > 0 {
+		chunk := zc.buf
+		if int64(len(chunk)) > n {
+			chunk = chunk[:n]
+		}
+		r, err := src.ReadAt(chunk, srcOff)
+		if r < len(chunk) {
+			if err == nil {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		if _, err := dst.WriteAt(chunk, dstOff); err != nil {
+			return err
+		}
+		n -= int64(len(chunk))
+		srcOff += int64(len(chunk))
+		dstOff += int64(len(chunk))
+	}
+	return nil
+}
+
+// batch returns the end index and total size of the batch of tiles starting at start, such that
+// the batch size does not exceed bufSize (unless it is made of a single tile). The total size
+// includes the padding between the tiles of the batch.
+func (p *tilePlan) batch(start int, bufSize int) (end int, total uint64) {
+	if bufSize <= 0 {
+		bufSize = DefaultCopyBufferSize
+	}
+	end = start
+	for end < p.Len() {
+		span := p.blockEnd(end) - p.blockStart(start)
+		if end > start && span > uint64(bufSize) {
+			break
+		}
+		total = span
+		end++
+	}
+	return end, total
+}
+
+// padding calls fn with the offset and size of the padding that precedes each of the tiles start
+// to end of the plan (and their leader)
+func (p *tilePlan) padding(start, end int, fn func(off, size uint64) error) error {
+	for i := start; i < end; i++ {
+		prev := p.dataOffset
+		if i > 0 {
+			prev = p.blockEnd(i - 1)
+		}
+		if next := p.blockStart(i); next > prev {
+			if err := fn(prev, next-prev); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func zero(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+// positional returns true if the tiles can be written to f at their offsets: f must be a regular
+// file, not opened in append mode. Pipes and devices are written sequentially.
+func positional(f *os.File) bool {
+	st, err := f.Stat()
+	return err == nil && st.Mode().IsRegular() && !appendOnly(f)
+}
+
+// hasFileSource returns true if one of the ifds of the plan was loaded from an *os.File
+func (p *tilePlan) hasFileSource() bool {
+	for _, ifd := range p.ifds {
+		if _, ok := ifd.src.(*os.File); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// sourceReads returns the reads needed to fetch the tiles start to end of the plan, sorted by
+// source file and offset, with contiguous tiles of a same source file merged in a single read.
+func (p *tilePlan) sourceReads(start, end int, readers map[tiff.BReader]int) []sourceRead {
+	order := make([]int, 0, end-start)
+	rids := make([]int, end-start)
+	for i := start; i < end; i++ {
+		ifd, _ := p.IFD(i)
+		rid, ok := readers[ifd.r]
+		if !ok {
+			rid = len(readers)
+			readers[ifd.r] = rid
+		}
+		rids[i-start] = rid
+		order = append(order, i)
+	}
+	srcOffset := func(i int) uint64 {
+		ifd, idx := p.IFD(i)
+		return ifd.OriginalTileOffsets[idx]
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		ri, rj := rids[order[i]-start], rids[order[j]-start]
+		return ri < rj || (ri == rj && srcOffset(order[i]) < srcOffset(order[j]))
+	})
+
+	var reads []sourceRead
+	first := 0
+	for k, i := range order {
+		off, size := srcOffset(i), uint64(p.size[i])
+		if n := len(reads); n > 0 && reads[n-1].rid == rids[i-start] && reads[n-1].offset+reads[n-1].size == off {
+			reads[n-1].size += size
+			reads[n-1].tiles = order[first : k+1]
+			continue
+		}
+		ifd, _ := p.IFD(i)
+		first = k
+		reads = append(reads, sourceRead{
+			r:      ifd.r,
+			rid:    rids[i-start],
+			offset: off,
+			size:   size,
+			tiles:  order[k : k+1],
+		})
+	}
+	return reads
+}
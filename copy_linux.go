@@ -0,0 +1,70 @@
+//go:build linux
+// +build linux
+
+package mucog
+
+import (
+	"errors"
+	"io"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// copyRange copies n bytes from src at srcOff to dst at dstOff, using copy_file_range, then
+// sendfile, then a generic copy, depending on what the kernel and filesystems support.
+func (zc *zeroCopier) copyRange(dst *os.File, dstOff int64, src *os.File, srcOff int64, n int64) error {
+	for n > 0 && !zc.noCopyFileRange {
+		w, err := unix.CopyFileRange(int(src.Fd()), &srcOff, int(dst.Fd()), &dstOff, int(n), 0)
+		if err != nil {
+			if unsupportedZeroCopy(err) {
+				zc.noCopyFileRange = true
+				break
+			}
+			return err
+		}
+		if w == 0 {
+			return io.ErrUnexpectedEOF
+		}
+		n -= int64(w)
+	}
+	if n > 0 && !zc.noSendfile {
+		// sendfile writes at the current offset of dst
+		if _, err := dst.Seek(dstOff, io.SeekStart); err != nil {
+			return err
+		}
+		for n > 0 {
+			w, err := syscall.Sendfile(int(dst.Fd()), int(src.Fd()), &srcOff, int(n))
+			if err != nil {
+				if unsupportedZeroCopy(err) {
+					zc.noSendfile = true
+					break
+				}
+				return err
+			}
+			if w == 0 {
+				return io.ErrUnexpectedEOF
+			}
+			n -= int64(w)
+			dstOff += int64(w)
+		}
+	}
+	if n > 0 {
+		return zc.genericCopy(dst, dstOff, src, srcOff, n)
+	}
+	return nil
+}
+
+// unsupportedZeroCopy returns true if err means the zero-copy syscall cannot be used for these files
+func unsupportedZeroCopy(err error) bool {
+	return errors.Is(err, syscall.ENOSYS) || errors.Is(err, syscall.EXDEV) ||
+		errors.Is(err, syscall.EINVAL) || errors.Is(err, syscall.EOPNOTSUPP) ||
+		errors.Is(err, syscall.EBADF)
+}
+
+// appendOnly returns true if f was opened with O_APPEND, in which case pwrite ignores the offset
+func appendOnly(f *os.File) bool {
+	flags, err := unix.FcntlInt(f.Fd(), unix.F_GETFL, 0)
+	return err != nil || flags&unix.O_APPEND != 0
+}
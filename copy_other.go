@@ -0,0 +1,17 @@
+//go:build !linux
+// +build !linux
+
+package mucog
+
+import "os"
+
+// copyRange copies n bytes from src at srcOff to dst at dstOff
+func (zc *zeroCopier) copyRange(dst *os.File, dstOff int64, src *os.File, srcOff int64, n int64) error {
+	return zc.genericCopy(dst, dstOff, src, srcOff, n)
+}
+
+// appendOnly returns true if f was opened with O_APPEND, which WriteAt rejects
+func appendOnly(f *os.File) bool {
+	_, err := f.WriteAt(nil, 0)
+	return err != nil
+}
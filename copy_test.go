@@ -0,0 +1,197 @@
+package mucog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/tiff"
+)
+
+// testTempFile creates a file in a temporary directory, closed and removed at the end of the test
+func testTempFile(t *testing.T, name string) *os.File {
+	t.Helper()
+	f, err := os.Create(filepath.Join(t.TempDir(), name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+func testInterlacedData() []byte {
+	data := make([]byte, 2*4*8)
+	for i := range data {
+		data[i] = byte(10*(i/32) + (i%32)/8)
+	}
+	return data
+}
+
+// testInterlacedCOG returns a mucog of two images of 4 tiles of 8 bytes, stored contiguously in a
+// single source file. Tile t of image i is filled with 10*i+t.
+func testInterlacedCOG(opts ...Option) *MultiCOG {
+	return testInterlacedCOGFrom(bytes.NewReader(testInterlacedData()), opts...)
+}
+
+func testInterlacedCOGFrom(src tiff.ReadAtReadSeeker, opts ...Option) *MultiCOG {
+	r := tiff.NewBReader(src, binary.LittleEndian)
+	cog := New(opts...)
+	for i := 0; i < 2; i++ {
+		ifd := testLayoutIFD(0, 4, 8)
+		ifd.r = r
+		ifd.src = src
+		for t := range ifd.OriginalTileOffsets {
+			ifd.OriginalTileOffsets[t] = uint64(32*i + 8*t)
+		}
+		cog.AppendIFD(ifd)
+	}
+	return cog
+}
+
+func TestSourceReads(t *testing.T) {
+	cog := testInterlacedCOG()
+	if err := cog.computeImageryOffsets(false, "I>L>T>P"); err != nil {
+		t.Fatal(err)
+	}
+	reads := cog.plan.sourceReads(0, cog.plan.Len(), map[tiff.BReader]int{})
+	if len(reads) != 1 || reads[0].size != 64 || len(reads[0].tiles) != 8 {
+		t.Errorf("expected a single read, got %+v", reads)
+	}
+
+	if err := cog.computeImageryOffsets(false, "L>T>I>P"); err != nil {
+		t.Fatal(err)
+	}
+	// tiles are interlaced in the output, but must still be read in a single pass
+	reads = cog.plan.sourceReads(0, cog.plan.Len(), map[tiff.BReader]int{})
+	if len(reads) != 1 || reads[0].size != 64 {
+		t.Fatalf("expected a single read, got %+v", reads)
+	}
+	for k, i := range reads[0].tiles {
+		ifd, idx := cog.plan.IFD(i)
+		if ifd.OriginalTileOffsets[idx] != uint64(8*k) {
+			t.Errorf("read tile %d: source offset %d", k, ifd.OriginalTileOffsets[idx])
+		}
+	}
+}
+
+func TestCopyTiles(t *testing.T) {
+	var outputs [][]byte
+	for _, bufSize := range []int{1, 20, 64, DefaultCopyBufferSize} {
+		cog := testInterlacedCOG(CopyBufferSize(bufSize))
+		out := &bytes.Buffer{}
+		if err := cog.Write(out, false, "L>T>I>P"); err != nil {
+			t.Fatal(err)
+		}
+		// tiles are written in plan order
+		tiles := out.Bytes()[cog.plan.offset[0]:]
+		for i := 0; i < cog.plan.Len(); i++ {
+			ifd, idx := cog.plan.IFD(i)
+			image := ifd.OriginalTileOffsets[idx] / 32
+			expected := bytes.Repeat([]byte{byte(10*image) + byte(idx)}, 8)
+			if !bytes.Equal(tiles[8*i:8*i+8], expected) {
+				t.Errorf("buffer %d, tile %d: got %v, expected %v", bufSize, i, tiles[8*i:8*i+8], expected)
+			}
+		}
+		outputs = append(outputs, out.Bytes())
+	}
+	for i := range outputs {
+		if !bytes.Equal(outputs[i], outputs[0]) {
+			t.Errorf("output %d differs", i)
+		}
+	}
+}
+
+func TestCopyTilesFile(t *testing.T) {
+	src := testTempFile(t, "src.bin")
+	dst := testTempFile(t, "dst.tif")
+	if _, err := src.Write(testInterlacedData()); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, pattern := range []string{"L>T>I>P", "I>L>T>P"} {
+		expected := &bytes.Buffer{}
+		if err := testInterlacedCOG().Write(expected, false, pattern); err != nil {
+			t.Fatal(err)
+		}
+		if err := dst.Truncate(0); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := dst.Seek(0, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		if err := testInterlacedCOGFrom(src, CopyBufferSize(20)).Write(dst, false, pattern); err != nil {
+			t.Fatal(err)
+		}
+		if pos, _ := dst.Seek(0, io.SeekCurrent); pos != int64(expected.Len()) {
+			t.Errorf("%s: output position %d, expected %d", pattern, pos, expected.Len())
+		}
+		got, err := os.ReadFile(dst.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, expected.Bytes()) {
+			t.Errorf("%s: output differs from generic copy", pattern)
+		}
+	}
+}
+
+func TestCopyTilesNotPositional(t *testing.T) {
+	src := testTempFile(t, "src.bin")
+	if _, err := src.Write(testInterlacedData()); err != nil {
+		t.Fatal(err)
+	}
+	expected := &bytes.Buffer{}
+	if err := testInterlacedCOG().Write(expected, false, "L>T>I>P"); err != nil {
+		t.Fatal(err)
+	}
+
+	// append only file, after some existing content
+	name := filepath.Join(t.TempDir(), "append.tif")
+	if err := os.WriteFile(name, []byte("head"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dst, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+	if positional(dst) {
+		t.Error("append only file reported as positional")
+	}
+	if err := testInterlacedCOGFrom(src).Write(dst, false, "L>T>I>P"); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, append([]byte("head"), expected.Bytes()...)) {
+		t.Error("append only output differs from generic copy")
+	}
+
+	// pipe
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if positional(w) {
+		t.Error("pipe reported as positional")
+	}
+	done := make(chan []byte)
+	go func() {
+		b, _ := io.ReadAll(r)
+		done <- b
+	}()
+	err = testInterlacedCOGFrom(src).Write(w, false, "L>T>I>P")
+	w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(<-done, expected.Bytes()) {
+		t.Error("pipe output differs from generic copy")
+	}
+}
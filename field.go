@@ -5,8 +5,12 @@ import (
 	"fmt"
 	"io"
 	"math"
+	"math/big"
 )
 
+// ifd8 is a list of IFD offsets, encoded with the IFD8 field type in bigtiff files
+type ifd8 []uint64
+
 func arrayFieldSize(data interface{}, bigtiff bool) uint64 {
 	if bigtiff {
 		switch d := data.(type) {
@@ -30,6 +34,16 @@ func arrayFieldSize(data interface{}, bigtiff bool) uint64 {
 				return 20
 			}
 			return uint64(20 + 8*len(d))
+		case ifd8:
+			if len(d) == 1 {
+				return 20
+			}
+			return uint64(20 + 8*len(d))
+		case []*big.Rat:
+			if len(d) == 1 {
+				return 20
+			}
+			return uint64(20 + 8*len(d))
 		case []int8:
 			if len(d) <= 8 {
 				return 20
@@ -116,6 +130,8 @@ func arrayFieldSize(data interface{}, bigtiff bool) uint64 {
 			return uint64(12 + len(d)*8)
 		case []uint64:
 			return uint64(12 + len(d)*8)
+		case []*big.Rat:
+			return uint64(12 + len(d)*8)
 		default:
 			panic("wrong type")
 		}
@@ -235,6 +251,46 @@ func (cog *MultiCOG) writeArray(w io.Writer, bigtiff bool, tag uint16, data inte
 				binary.Write(tags, cog.enc, d[i])
 			}
 		}
+	case ifd8:
+		if !bigtiff {
+			return fmt.Errorf("IFD8 offsets are only supported in bigtiff")
+		}
+		n := len(d)
+		cog.enc.PutUint16(buf[2:4], TIFD8)
+		cog.enc.PutUint64(buf[4:12], uint64(n))
+		if n == 1 {
+			cog.enc.PutUint64(buf[12:], d[0])
+		} else {
+			cog.enc.PutUint64(buf[12:], tags.NextOffset())
+			for i := 0; i < n; i++ {
+				binary.Write(tags, cog.enc, d[i])
+			}
+		}
+	case []*big.Rat:
+		n := len(d)
+		vals := make([]byte, 8*n)
+		for i, r := range d {
+			if r.Sign() < 0 || !r.Num().IsUint64() || r.Num().Uint64() > math.MaxUint32 ||
+				r.Denom().Uint64() > math.MaxUint32 {
+				return fmt.Errorf("rational %s cannot be encoded", r.String())
+			}
+			cog.enc.PutUint32(vals[8*i:], uint32(r.Num().Uint64()))
+			cog.enc.PutUint32(vals[8*i+4:], uint32(r.Denom().Uint64()))
+		}
+		cog.enc.PutUint16(buf[2:4], TRational)
+		if bigtiff {
+			cog.enc.PutUint64(buf[4:12], uint64(n))
+			if n == 1 {
+				copy(buf[12:], vals)
+			} else {
+				cog.enc.PutUint64(buf[12:], tags.NextOffset())
+				tags.Write(vals)
+			}
+		} else {
+			cog.enc.PutUint32(buf[4:8], uint32(n))
+			cog.enc.PutUint32(buf[8:], uint32(tags.NextOffset()))
+			tags.Write(vals)
+		}
 	case []float32:
 		n := len(d)
 		cog.enc.PutUint16(buf[2:4], TFloat)
@@ -0,0 +1,162 @@
+package mucog
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	GTModelTypeGeoKey      = 1024
+	GTCitationGeoKey       = 1026
+	GeographicTypeGeoKey   = 2048
+	GeogCitationGeoKey     = 2049
+	ProjectedCSTypeGeoKey  = 3072
+	PCSCitationGeoKey      = 3073
+	VerticalCSTypeGeoKey   = 4096
+	VerticalCitationGeoKey = 4097
+
+	ModelTypeProjected  = 1
+	ModelTypeGeographic = 2
+	ModelTypeGeocentric = 3
+)
+
+const (
+	geoKeyUserDefined            = 32767
+	geoKeyDirectoryTagLocation   = 34735
+	geoDoubleParamsTagLocation   = 34736
+	geoAsciiParamsTagLocation    = 34737
+	geoKeyDirectoryHeaderLength  = 4
+	geoKeyDirectoryEntryLength   = 4
+	geoKeyDirectoryKeyCountIndex = 3
+)
+
+// GeoKey is a key of the GeoKeyDirectoryTag, with its value resolved from the GeoDoubleParamsTag
+// or GeoAsciiParamsTag if needed. Only one of Short, Double and Ascii is set.
+type GeoKey struct {
+	ID     uint16
+	Short  []uint16
+	Double []float64
+	Ascii  string
+}
+
+func (k GeoKey) String() string {
+	switch {
+	case k.Double != nil:
+		return fmt.Sprintf("%d=%v", k.ID, k.Double)
+	case k.Short != nil:
+		if len(k.Short) == 1 {
+			return fmt.Sprintf("%d=%d", k.ID, k.Short[0])
+		}
+		return fmt.Sprintf("%d=%v", k.ID, k.Short)
+	default:
+		return fmt.Sprintf("%d=%q", k.ID, k.Ascii)
+	}
+}
+
+// GeoKeys parses the GeoKeyDirectoryTag of the ifd
+func (ifd *IFD) GeoKeys() ([]GeoKey, error) {
+	dir := ifd.GeoKeyDirectoryTag
+	if len(dir) == 0 {
+		return nil, nil
+	}
+	if len(dir) < geoKeyDirectoryHeaderLength {
+		return nil, fmt.Errorf("truncated geokey directory header")
+	}
+	n := int(dir[geoKeyDirectoryKeyCountIndex])
+	if len(dir) < geoKeyDirectoryHeaderLength+n*geoKeyDirectoryEntryLength {
+		return nil, fmt.Errorf("geokey directory of %d keys is truncated", n)
+	}
+	keys := make([]GeoKey, 0, n)
+	for k := 0; k < n; k++ {
+		entry := dir[geoKeyDirectoryHeaderLength+k*geoKeyDirectoryEntryLength:]
+		id, location, count, value := entry[0], entry[1], int(entry[2]), int(entry[3])
+		key := GeoKey{ID: id}
+		switch location {
+		case 0:
+			key.Short = []uint16{uint16(value)}
+		case geoKeyDirectoryTagLocation:
+			if value+count > len(dir) {
+				return nil, fmt.Errorf("geokey %d: short values out of directory", id)
+			}
+			key.Short = append([]uint16{}, dir[value:value+count]...)
+		case geoDoubleParamsTagLocation:
+			if value+count > len(ifd.GeoDoubleParamsTag) {
+				return nil, fmt.Errorf("geokey %d: double values out of GeoDoubleParamsTag", id)
+			}
+			key.Double = append([]float64{}, ifd.GeoDoubleParamsTag[value:value+count]...)
+		case geoAsciiParamsTagLocation:
+			if value+count > len(ifd.GeoAsciiParamsTag) {
+				return nil, fmt.Errorf("geokey %d: ascii value out of GeoAsciiParamsTag", id)
+			}
+			// values are terminated by a "|" in the GeoAsciiParamsTag
+			key.Ascii = strings.TrimRight(ifd.GeoAsciiParamsTag[value:value+count], "|\x00")
+		default:
+			return nil, fmt.Errorf("geokey %d: unsupported location %d", id, location)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// CRS is a comparable description of the coordinate reference system of an image. It is
+// identified by the EPSG code of its projected or geographic system when there is one,
+// and by the set of its defining geokeys otherwise.
+type CRS struct {
+	EPSG int    // EPSG code, 0 if the crs is user defined or unknown
+	Keys string // defining geokeys that are not covered by EPSG, e.g. vertical crs or user defined parameters
+}
+
+func (c CRS) String() string {
+	switch {
+	case c.EPSG != 0 && c.Keys != "":
+		return fmt.Sprintf("EPSG:%d (%s)", c.EPSG, c.Keys)
+	case c.EPSG != 0:
+		return fmt.Sprintf("EPSG:%d", c.EPSG)
+	case c.Keys != "":
+		return fmt.Sprintf("user defined (%s)", c.Keys)
+	default:
+		return "unknown"
+	}
+}
+
+// CRS returns the coordinate reference system described by the geokeys of the ifd
+func (ifd *IFD) CRS() (CRS, error) {
+	keys, err := ifd.GeoKeys()
+	if err != nil {
+		return CRS{}, err
+	}
+	crs := CRS{}
+	short := map[uint16]uint16{}
+	for _, k := range keys {
+		if len(k.Short) == 1 {
+			short[k.ID] = k.Short[0]
+		}
+	}
+	code := uint16(0)
+	switch short[GTModelTypeGeoKey] {
+	case ModelTypeProjected:
+		code = short[ProjectedCSTypeGeoKey]
+	case ModelTypeGeographic:
+		code = short[GeographicTypeGeoKey]
+	}
+	if code != 0 && code != geoKeyUserDefined {
+		crs.EPSG = int(code)
+	}
+
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+	var defining []string
+	for _, k := range keys {
+		switch {
+		case k.ID == GTRasterTypeGeoKey || k.ID == GTCitationGeoKey || k.ID == GeogCitationGeoKey ||
+			k.ID == PCSCitationGeoKey || k.ID == VerticalCitationGeoKey:
+			// not part of the crs definition
+		case crs.EPSG != 0 && k.ID < VerticalCSTypeGeoKey:
+			// horizontal crs keys are covered by the EPSG code
+		default:
+			defining = append(defining, k.String())
+		}
+	}
+	crs.Keys = strings.Join(defining, ",")
+	return crs, nil
+}
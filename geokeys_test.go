@@ -0,0 +1,88 @@
+package mucog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	"github.com/google/tiff"
+)
+
+func testUTMKeys(zone uint16, citation string) []uint16 {
+	return []uint16{
+		1, 1, 0, 4,
+		GTModelTypeGeoKey, 0, 1, ModelTypeProjected,
+		GTRasterTypeGeoKey, 0, 1, RasterPixelIsArea,
+		GTCitationGeoKey, geoAsciiParamsTagLocation, uint16(len(citation) + 1), 0,
+		ProjectedCSTypeGeoKey, 0, 1, 32600 + zone,
+	}
+}
+
+func TestGeoKeys(t *testing.T) {
+	ifd := &IFD{
+		GeoKeyDirectoryTag: []uint16{
+			1, 1, 0, 4,
+			GTModelTypeGeoKey, 0, 1, ModelTypeProjected,
+			GeogCitationGeoKey, geoAsciiParamsTagLocation, 7, 0,
+			ProjectedCSTypeGeoKey, 0, 1, geoKeyUserDefined,
+			3078, geoDoubleParamsTagLocation, 2, 1,
+		},
+		GeoDoubleParamsTag: []float64{1, 45, 46},
+		GeoAsciiParamsTag:  "WGS 84|",
+	}
+	keys, err := ifd.GeoKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 4 || keys[1].Ascii != "WGS 84" || len(keys[3].Double) != 2 || keys[3].Double[1] != 46 {
+		t.Errorf("unexpected keys %v", keys)
+	}
+	crs, err := ifd.CRS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if crs.EPSG != 0 || crs.Keys != "1024=1,3072=32767,3078=[45 46]" {
+		t.Errorf("unexpected user defined crs %s", crs)
+	}
+
+	ifd.GeoDoubleParamsTag = ifd.GeoDoubleParamsTag[:2]
+	if _, err := ifd.GeoKeys(); err == nil {
+		t.Error("expected out of bounds error")
+	}
+	ifd.GeoKeyDirectoryTag = ifd.GeoKeyDirectoryTag[:10]
+	if _, err := ifd.GeoKeys(); err == nil {
+		t.Error("expected truncated directory error")
+	}
+
+	// citations do not take part in the comparison
+	a := &IFD{GeoKeyDirectoryTag: testUTMKeys(31, "UTM 31"), GeoAsciiParamsTag: "UTM 31|"}
+	b := &IFD{GeoKeyDirectoryTag: testUTMKeys(31, "WGS 84 / UTM zone 31N"), GeoAsciiParamsTag: "WGS 84 / UTM zone 31N|"}
+	ca, _ := a.CRS()
+	cb, _ := b.CRS()
+	if ca != cb || ca.String() != "EPSG:32631" {
+		t.Errorf("crs %s and %s should be equal", ca, cb)
+	}
+}
+
+func TestCRSConsistency(t *testing.T) {
+	r := tiff.NewBReader(bytes.NewReader(make([]byte, 16)), binary.LittleEndian)
+	newCOG := func(zones ...uint16) *MultiCOG {
+		cog := New()
+		for i, zone := range zones {
+			ifd := testLayoutIFD(float64(16*i), 1, 1)
+			ifd.r = r
+			ifd.GeoKeyDirectoryTag = testUTMKeys(zone, "UTM")
+			ifd.GeoAsciiParamsTag = "UTM|"
+			cog.AppendIFD(ifd)
+		}
+		return cog
+	}
+	if err := newCOG(31, 31).computeImageryOffsets(false, MUCOGPattern); err != nil {
+		t.Error(err)
+	}
+	err := newCOG(31, 31, 32).computeImageryOffsets(false, MUCOGPattern)
+	if err == nil || !strings.Contains(err.Error(), "ifd 2 crs EPSG:32632 differs from ifd 0 crs EPSG:32631") {
+		t.Errorf("expected crs error, got %v", err)
+	}
+}
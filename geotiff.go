@@ -3,6 +3,7 @@ package mucog
 import (
 	"errors"
 	"fmt"
+	"math"
 )
 
 type geotransform [6]float64
@@ -15,6 +16,28 @@ func (gt geotransform) Scale() (float64, float64) {
 	return gt[1], gt[5]
 }
 
+// PixelSize returns the length of the steps of one pixel along the columns and rows of the image
+func (gt geotransform) PixelSize() (float64, float64) {
+	return math.Hypot(gt[1], gt[4]), math.Hypot(gt[2], gt[5])
+}
+
+// basisDeviation compares the steps of one pixel along the columns and rows of the image with
+// the ones of ref. It returns the largest difference relative to the step of ref, and whether
+// the steps point in the same directions within tolerance, i.e. whether the grids share the
+// same rotation and flips.
+func (gt geotransform) basisDeviation(ref geotransform, tolerance float64) (float64, bool) {
+	dev, oriented := 0.0, true
+	for _, c := range [][2]int{{1, 4}, {2, 5}} {
+		ax, ay, bx, by := gt[c[0]], gt[c[1]], ref[c[0]], ref[c[1]]
+		na, nb := math.Hypot(ax, ay), math.Hypot(bx, by)
+		dev = math.Max(dev, math.Hypot(ax-bx, ay-by)/nb)
+		if ax*bx+ay*by <= 0 || math.Abs(ax*by-ay*bx)/(na*nb) > tolerance {
+			oriented = false
+		}
+	}
+	return dev, oriented
+}
+
 func (gt geotransform) Transform(x, y float64) (float64, float64) {
 	return gt[0] + gt[1]*x + gt[2]*y, gt[3] + gt[4]*x + gt[5]*y
 }
@@ -29,8 +52,79 @@ func (gt geotransform) Inverse() (geotransform, error) {
 	return res, nil
 }
 
+const (
+	GTRasterTypeGeoKey = 1025
+
+	RasterPixelIsArea  = 1
+	RasterPixelIsPoint = 2
+)
+
+// NormalizeRasterType rewrites the georeferencing of the images whose raster type (PixelIsArea
+// or PixelIsPoint) differs from the one of the first image, instead of rejecting them.
+func NormalizeRasterType() Option {
+	return func(cog *MultiCOG) {
+		cog.normalizeRasterType = true
+	}
+}
+
+func rasterTypeName(rt uint16) string {
+	if rt == RasterPixelIsPoint {
+		return "PixelIsPoint"
+	}
+	return "PixelIsArea"
+}
+
+// geoKeyShort returns the value of a SHORT geokey of the GeoKeyDirectoryTag
+func (ifd *IFD) geoKeyShort(id uint16) (uint16, bool) {
+	dir := ifd.GeoKeyDirectoryTag
+	if len(dir) < 4 {
+		return 0, false
+	}
+	for k := 0; k < int(dir[3]) && 4*k+7 < len(dir); k++ {
+		entry := dir[4*k+4 : 4*k+8]
+		if entry[0] == id && entry[1] == 0 {
+			return entry[3], true
+		}
+	}
+	return 0, false
+}
+
+// setGeoKeyShort sets the value of a SHORT geokey of the GeoKeyDirectoryTag, adding it if needed
+func (ifd *IFD) setGeoKeyShort(id, value uint16) {
+	dir := append([]uint16{}, ifd.GeoKeyDirectoryTag...)
+	if len(dir) < 4 {
+		dir = []uint16{1, 1, 0, 0}
+	}
+	k := 0
+	for ; k < int(dir[3]) && 4*k+7 < len(dir); k++ {
+		entry := dir[4*k+4 : 4*k+8]
+		if entry[0] == id {
+			entry[1], entry[2], entry[3] = 0, 1, value
+			ifd.GeoKeyDirectoryTag = dir
+			return
+		}
+		if entry[0] > id {
+			break
+		}
+	}
+	// keys are sorted by id
+	at := 4*k + 4
+	dir = append(dir[:at], append([]uint16{id, 0, 1, value}, dir[at:]...)...)
+	dir[3]++
+	ifd.GeoKeyDirectoryTag = dir
+}
+
+// rasterType returns the GTRasterTypeGeoKey of the ifd, PixelIsArea if it is not set
+func (ifd *IFD) rasterType() uint16 {
+	if rt, ok := ifd.geoKeyShort(GTRasterTypeGeoKey); ok && rt == RasterPixelIsPoint {
+		return RasterPixelIsPoint
+	}
+	return RasterPixelIsArea
+}
+
+// geotransform returns the geotransform of the ifd, mapping the corner of the pixels (i.e.
+// PixelIsArea), as done by GDAL
 func (ifd *IFD) geotransform() (geotransform, error) {
-	//TODO: check and return error if geotiff ix PIXELISPOINT
 	gt := geotransform{0, 1, 0, 0, 0, 1}
 	if len(ifd.ModelPixelScaleTag) >= 2 &&
 		ifd.ModelPixelScaleTag[0] != 0 && ifd.ModelPixelScaleTag[1] != 0 {
@@ -55,5 +149,30 @@ func (ifd *IFD) geotransform() (geotransform, error) {
 	} else {
 		return gt, errors.New("no geotiff referencing computed")
 	}
+	if ifd.rasterType() == RasterPixelIsPoint {
+		// the referencing is relative to the center of the pixels
+		gt[0], gt[3] = gt.Transform(-0.5, -0.5)
+	}
 	return gt, nil
 }
+
+// setGeoreferencing sets the referencing tags of the ifd to represent gt (relative to the corner
+// of the pixels), according to the raster type of the ifd
+func (ifd *IFD) setGeoreferencing(gt geotransform) {
+	if ifd.rasterType() == RasterPixelIsPoint {
+		gt[0], gt[3] = gt.Transform(0.5, 0.5)
+	}
+	if gt[2] == 0 && gt[4] == 0 && len(ifd.ModelTransformationTag) == 0 {
+		ifd.ModelPixelScaleTag = []float64{gt[1], -gt[5], 0}
+		ifd.ModelTiePointTag = []float64{0, 0, 0, gt[0], gt[3], 0}
+		return
+	}
+	ifd.ModelPixelScaleTag = nil
+	ifd.ModelTiePointTag = nil
+	ifd.ModelTransformationTag = []float64{
+		gt[1], gt[2], 0, gt[0],
+		gt[4], gt[5], 0, gt[3],
+		0, 0, 0, 0,
+		0, 0, 0, 1,
+	}
+}
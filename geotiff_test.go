@@ -0,0 +1,93 @@
+package mucog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/google/tiff"
+)
+
+func TestGeoKeyShort(t *testing.T) {
+	ifd := &IFD{}
+	if _, ok := ifd.geoKeyShort(GTRasterTypeGeoKey); ok {
+		t.Error("unexpected key in empty directory")
+	}
+	ifd.GeoKeyDirectoryTag = []uint16{1, 1, 0, 2, 1024, 0, 1, 1, 3072, 0, 1, 32631}
+	ifd.setGeoKeyShort(GTRasterTypeGeoKey, RasterPixelIsPoint)
+	expected := []uint16{1, 1, 0, 3, 1024, 0, 1, 1, 1025, 0, 1, 2, 3072, 0, 1, 32631}
+	if len(ifd.GeoKeyDirectoryTag) != len(expected) {
+		t.Fatalf("directory %v", ifd.GeoKeyDirectoryTag)
+	}
+	for i := range expected {
+		if ifd.GeoKeyDirectoryTag[i] != expected[i] {
+			t.Fatalf("directory %v, expected %v", ifd.GeoKeyDirectoryTag, expected)
+		}
+	}
+	ifd.setGeoKeyShort(GTRasterTypeGeoKey, RasterPixelIsArea)
+	if v, ok := ifd.geoKeyShort(GTRasterTypeGeoKey); !ok || v != RasterPixelIsArea || len(ifd.GeoKeyDirectoryTag) != 16 {
+		t.Errorf("raster type %d, directory %v", v, ifd.GeoKeyDirectoryTag)
+	}
+	if v, _ := ifd.geoKeyShort(3072); v != 32631 {
+		t.Errorf("projected crs %d", v)
+	}
+}
+
+// testPointIFD returns a PixelIsPoint image whose pixel corner is at (ox, 0)
+func testPointIFD(r tiff.BReader, ox float64) *IFD {
+	ifd := testLayoutIFD(0, 2, 1)
+	ifd.r = r
+	ifd.ModelTiePointTag = []float64{0, 0, 0, ox + 0.5, -0.5, 0}
+	ifd.setGeoKeyShort(GTRasterTypeGeoKey, RasterPixelIsPoint)
+	return ifd
+}
+
+func TestPixelIsPoint(t *testing.T) {
+	r := tiff.NewBReader(bytes.NewReader(make([]byte, 16)), binary.LittleEndian)
+	gt, err := testPointIFD(r, 16).geotransform()
+	if err != nil || gt != (geotransform{16, 1, 0, 0, 0, -1}) {
+		t.Errorf("geotransform %v, %v", gt, err)
+	}
+
+	newCOG := func(opts ...Option) *MultiCOG {
+		cog := New(opts...)
+		area := testLayoutIFD(0, 2, 1)
+		area.r = r
+		cog.AppendIFD(area)
+		cog.AppendIFD(testPointIFD(r, 16))
+		return cog
+	}
+	if err := newCOG().computeImageryOffsets(false, MUCOGPattern); err == nil {
+		t.Error("expected raster type error")
+	}
+
+	cog := newCOG(NormalizeRasterType())
+	// Check does not normalize the images
+	if report, err := cog.Check(); err != nil || len(report.Issues) != 0 {
+		t.Fatalf("got issues %v, %v", report.Issues, err)
+	}
+	if ifd := cog.ifds[1]; ifd.rasterType() != RasterPixelIsPoint || ifd.gt != (geotransform{}) {
+		t.Errorf("checked ifd: raster type %d, geotransform %v", ifd.rasterType(), ifd.gt)
+	}
+	if err := cog.computeImageryOffsets(false, MUCOGPattern); err != nil {
+		t.Fatal(err)
+	}
+	ifd := cog.ifds[1]
+	if ifd.minx != 1 || ifd.rasterType() != RasterPixelIsArea {
+		t.Errorf("normalized ifd: minx %d, raster type %d", ifd.minx, ifd.rasterType())
+	}
+	if gt, _ := ifd.geotransform(); gt != (geotransform{16, 1, 0, 0, 0, -1}) {
+		t.Errorf("normalized geotransform %v", gt)
+	}
+
+	// images sharing the PixelIsPoint raster type are aligned on their pixel corners
+	cog = New()
+	cog.AppendIFD(testPointIFD(r, 0))
+	cog.AppendIFD(testPointIFD(r, 32))
+	if err := cog.computeImageryOffsets(false, MUCOGPattern); err != nil {
+		t.Fatal(err)
+	}
+	if cog.ifds[1].minx != 2 {
+		t.Errorf("minx %d", cog.ifds[1].minx)
+	}
+}
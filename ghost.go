@@ -0,0 +1,66 @@
+package mucog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// blockFrameSize is the size of the block leader and of the block trailer of a tile
+const blockFrameSize = 4
+
+// GhostArea writes a GDAL structural metadata ghost area after the tiff header, and wraps each
+// tile with a block leader (the size of the tile as a little endian uint32) and a block trailer
+// (the last 4 bytes of the tile repeated), as done by the GDAL COG driver. This lets GDAL
+// avoid reading the strile arrays, and detect files that were modified after their creation.
+//
+// BLOCK_ORDER=ROW_MAJOR is only declared when the tiles of each IFD are contiguous and in
+// increasing order in the mucog, which is not the case of interlaced patterns.
+func GhostArea() Option {
+	return func(cog *MultiCOG) {
+		cog.ghost = true
+	}
+}
+
+// ghostArea returns the GDAL structural metadata describing the layout of the plan
+func (p *tilePlan) ghostArea(aligned bool) []byte {
+	md := &bytes.Buffer{}
+	md.WriteString("LAYOUT=IFDS_BEFORE_DATA\n")
+	if !aligned && p.rowMajor() {
+		md.WriteString("BLOCK_ORDER=ROW_MAJOR\n")
+	}
+	md.WriteString("BLOCK_LEADER=SIZE_AS_UINT4\n")
+	md.WriteString("BLOCK_TRAILER=LAST_4_BYTES_REPEATED\n")
+	md.WriteString("KNOWN_INCOMPATIBLE_EDITION=NO\n")
+	md.WriteString(" ") // padding, as done by GDAL
+	return append([]byte(fmt.Sprintf("GDAL_STRUCTURAL_METADATA_SIZE=%06d bytes\n", md.Len())), md.Bytes()...)
+}
+
+// rowMajor returns true if the tiles of each ifd of the plan are contiguous, in increasing order
+func (p *tilePlan) rowMajor() bool {
+	done := make([]bool, len(p.ifds))
+	for i := 0; i < p.Len(); i++ {
+		if i > 0 && p.ifd[i] == p.ifd[i-1] {
+			if p.tile[i] <= p.tile[i-1] {
+				return false
+			}
+			continue
+		}
+		if done[p.ifd[i]] {
+			return false
+		}
+		done[p.ifd[i]] = true
+	}
+	return true
+}
+
+// blockFrame returns the leader and the trailer of a tile of the given size, tail being the end
+// of the tile data. The trailer of a tile shorter than 4 bytes is left-padded with zeros.
+func blockFrame(size uint32, tail []byte) (leader, trailer [blockFrameSize]byte) {
+	binary.LittleEndian.PutUint32(leader[:], size)
+	if len(tail) > blockFrameSize {
+		tail = tail[len(tail)-blockFrameSize:]
+	}
+	copy(trailer[blockFrameSize-len(tail):], tail)
+	return leader, trailer
+}
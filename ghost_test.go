@@ -0,0 +1,115 @@
+package mucog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/tiff"
+)
+
+// checkBlockFrames checks the leader and trailer of each tile of the plan of cog, written to data
+func checkBlockFrames(t *testing.T, cog *MultiCOG, data []byte) {
+	t.Helper()
+	for i := 0; i < cog.plan.Len(); i++ {
+		off, size := cog.plan.offset[i], uint64(cog.plan.size[i])
+		if leader := binary.LittleEndian.Uint32(data[off-4:]); leader != uint32(size) {
+			t.Errorf("tile %d: leader %d, expected %d", i, leader, size)
+		}
+		if !bytes.Equal(data[off+size:off+size+4], data[off+size-4:off+size]) {
+			t.Errorf("tile %d: trailer %v, expected %v", i, data[off+size:off+size+4], data[off+size-4:off+size])
+		}
+	}
+}
+
+func TestGhostArea(t *testing.T) {
+	src := testTempFile(t, "src.bin")
+	dst := testTempFile(t, "dst.tif")
+	if _, err := src.Write(testInterlacedData()); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		pattern  string
+		rowMajor bool
+	}{
+		{"I>L>T>P", true},
+		{"L>T>I>P", false},
+	} {
+		cog := testInterlacedCOG(GhostArea())
+		out := &bytes.Buffer{}
+		if err := cog.Write(out, false, tc.pattern); err != nil {
+			t.Fatal(err)
+		}
+		data := out.Bytes()
+		ghost := string(data[8:cog.firstIFDOffset(false)])
+		if tc.rowMajor && !strings.HasPrefix(ghost, "GDAL_STRUCTURAL_METADATA_SIZE=000140 bytes\n") {
+			t.Errorf("%s: unexpected ghost area %q", tc.pattern, ghost)
+		}
+		if strings.Contains(ghost, "BLOCK_ORDER=ROW_MAJOR") != tc.rowMajor {
+			t.Errorf("%s: unexpected block order in %q", tc.pattern, ghost)
+		}
+		checkBlockFrames(t, cog, data)
+		st, err := cog.Stats(false, tc.pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if st.Framing != 8*8 || st.Padding != 0 || st.Size != uint64(len(data)) {
+			t.Errorf("%s: unexpected stats %+v", tc.pattern, st)
+		}
+
+		tif, err := tiff.Parse(bytes.NewReader(data), nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ifds, err := LoadTIFF(tif)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tile := readTile(t, ifds[1], 2); !bytes.Equal(tile, bytes.Repeat([]byte{12}, 8)) {
+			t.Errorf("%s: tile %v", tc.pattern, tile)
+		}
+
+		// zero-copy and virtual outputs are framed the same way
+		if err := dst.Truncate(0); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := dst.Seek(0, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		if err := testInterlacedCOGFrom(src, GhostArea()).Write(dst, false, tc.pattern); err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := os.ReadFile(dst.Name()); !bytes.Equal(got, data) {
+			t.Errorf("%s: file output differs", tc.pattern)
+		}
+		vf, err := testInterlacedCOG(GhostArea()).VirtualFile(false, tc.pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := io.ReadAll(io.NewSectionReader(vf, 0, vf.Size())); !bytes.Equal(got, data) {
+			t.Errorf("%s: virtual output differs", tc.pattern)
+		}
+	}
+}
+
+func TestGhostAreaSwap(t *testing.T) {
+	values := make([]uint16, 16*16)
+	for i := range values {
+		values[i] = uint16(i)
+	}
+	cog := New(ByteOrder(binary.BigEndian), GhostArea(), Alignment(64, 0))
+	cog.AppendIFD(testUInt16IFD(binary.LittleEndian, values))
+	out := &bytes.Buffer{}
+	if err := cog.Write(out, true, MUCOGPattern); err != nil {
+		t.Fatal(err)
+	}
+	checkBlockFrames(t, cog, out.Bytes())
+	off := cog.plan.offset[0]
+	if off%64 != 0 || binary.BigEndian.Uint16(out.Bytes()[off+2:]) != 1 {
+		t.Errorf("unexpected tile at %d", off)
+	}
+}
@@ -7,4 +7,6 @@ require (
 	github.com/airbusgeo/godal v0.0.0-20210506122000-ee62c71eebf8
 	github.com/airbusgeo/osio v0.0.0-20210506100101-26770c6cce5a
 	github.com/google/tiff v0.0.0-20161109161721-4b31f3041d9a
+	golang.org/x/image v0.0.0-20190802002840-cff245a6509b
+	golang.org/x/sys v0.0.0-20210503080704-8803ae5d1324
 )
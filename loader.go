@@ -2,61 +2,149 @@ package mucog
 
 import (
 	"fmt"
+	"io"
+	"math"
 
 	"github.com/google/tiff"
 	"github.com/google/tiff/bigtiff"
 )
 
-func LoadTIFF(tif tiff.TIFF) ([]*IFD, error) {
-	ntop := 0
-	nLegacyIFDs := 0 //number of top level ifds that are actually an overview or a mask
-	topidx := -1
-	for i, ifd := range tif.IFDs() {
-		if !ifd.HasField(254) {
-			ntop++
-			topidx = i
-		} else {
-			nLegacyIFDs++
-		}
+// LoadOption configures LoadTIFF
+type LoadOption func(o *loadOptions)
+
+type loadOptions struct {
+	src                       io.ReaderAt
+	data                      tiff.ReadAtReadSeeker
+	retileWidth, retileLength uint16
+}
+
+// LoadSource declares the reader the tiff was parsed from. When it is an *os.File, and the
+// mucog is written to an *os.File, tiles are copied without going through user space where
+// the platform allows it.
+func LoadSource(r io.ReaderAt) LoadOption {
+	return func(o *loadOptions) {
+		o.src = r
 	}
-	//we support multiple top level ifds provided there are no legacy ones
-	if ntop == 0 ||
-		(ntop > 1 && nLegacyIFDs > 0) {
-		return nil, fmt.Errorf("unsupported combination of top level/legacy IFDs: %d/%d", ntop, nLegacyIFDs)
+}
+
+// LoadTileData reads the tiles from r instead of the tiff, whose tile offsets are then offsets in
+// r. This is the case of the header written by WriteSidecar, r being its data file.
+func LoadTileData(r tiff.ReadAtReadSeeker) LoadOption {
+	return func(o *loadOptions) {
+		o.data = r
 	}
-	mifds := []*IFD{}
+}
+
+func LoadTIFF(tif tiff.TIFF, opts ...LoadOption) ([]*IFD, error) {
+	o := loadOptions{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
 	isbigtiff := tif.Version() == bigtiff.Version
-	if nLegacyIFDs == 0 {
-		for _, ifd := range tif.IFDs() {
-			mifd, err := loadIFD(tif.R(), ifd, isbigtiff)
-			if err != nil {
-				return nil, err
-			}
-			mifds = append(mifds, mifd)
-		}
-	} else {
-		mifd, err := loadIFD(tif.R(), tif.IFDs()[topidx], isbigtiff)
+	mifds := []*IFD{}
+	var tops []int //position of the top level ifds in the file
+	var legacy []*IFD
+	var legacyPos []int
+	for i, ifd := range tif.IFDs() {
+		mifd, err := loadIFD(tif.R(), ifd, isbigtiff, &o)
 		if err != nil {
 			return nil, err
 		}
-		for i, ifd := range tif.IFDs() {
-			if i == topidx {
+		//top level ifds that are actually an overview or a mask
+		if mifd.SubfileType&(SubfileTypeReducedImage|SubfileTypeMask) != 0 {
+			legacy = append(legacy, mifd)
+			legacyPos = append(legacyPos, i)
+			continue
+		}
+		mifds = append(mifds, mifd)
+		tops = append(tops, i)
+	}
+	if len(mifds) == 0 {
+		return nil, fmt.Errorf("unsupported combination of top level/legacy IFDs: %d/%d", len(mifds), len(legacy))
+	}
+	for l, lifd := range legacy {
+		parent := 0
+		if len(mifds) > 1 {
+			var err error
+			if parent, err = legacyParent(mifds, tops, lifd, legacyPos[l]); err != nil {
+				return nil, fmt.Errorf("legacy ifd %d: %w", legacyPos[l], err)
+			}
+		}
+		mifds[parent].SubIFDs = append(mifds[parent].SubIFDs, lifd)
+		mifds[parent].SubIFDOffsets = append(mifds[parent].SubIFDOffsets, 0)
+	}
+	var data tiff.BReader
+	if o.data != nil {
+		o.src = o.data
+		data = tiff.NewBReader(o.data, tif.R().ByteOrder())
+	}
+	for _, mifd := range mifds {
+		for _, ifd := range append([]*IFD{mifd}, mifd.SubIFDs...) {
+			if ifd.retiled {
 				continue
 			}
-			sifd, err := loadIFD(tif.R(), ifd, isbigtiff)
-			if err != nil {
-				return nil, err
+			ifd.src = o.src
+			if data != nil {
+				ifd.r = data
 			}
-			mifd.SubIFDs = append(mifd.SubIFDs, sifd)
-			mifd.SubIFDOffsets = append(mifd.SubIFDOffsets, 0)
 		}
-		mifds = append(mifds, mifd)
 	}
 	return mifds, nil
 }
 
-func loadIFD(r tiff.BReader, tifd tiff.IFD, isbigtiff bool) (*IFD, error) {
-	err := sanityCheckIFD(tifd)
+// legacyParent returns the top level ifd a legacy overview or mask at position pos in the file
+// belongs to, i.e. the closest preceding one (or following one if there is none) whose size is
+// compatible. Several multi-page layouts are ambiguous (e.g. all the pages followed by all the
+// overviews), which is detected when the parent would hold two ifds of the same kind and size.
+func legacyParent(tops []*IFD, pos []int, lifd *IFD, lpos int) (int, error) {
+	candidates := []int{}
+	for t := len(tops) - 1; t >= 0; t-- {
+		if pos[t] < lpos {
+			candidates = append(candidates, t)
+		}
+	}
+	for t := range tops {
+		if pos[t] > lpos {
+			candidates = append(candidates, t)
+		}
+	}
+	for _, t := range candidates {
+		top := tops[t]
+		if !reducedFrom(lifd.ImageWidth, lifd.ImageLength, top.ImageWidth, top.ImageLength) {
+			continue
+		}
+		for _, sifd := range top.SubIFDs {
+			if sifd.SubfileType == lifd.SubfileType &&
+				sifd.ImageWidth == lifd.ImageWidth && sifd.ImageLength == lifd.ImageLength {
+				return 0, fmt.Errorf("ambiguous parent: top level ifd %d already holds a %dx%d ifd of subfiletype %d",
+					pos[t], lifd.ImageWidth, lifd.ImageLength, lifd.SubfileType)
+			}
+		}
+		return t, nil
+	}
+	return 0, fmt.Errorf("no top level ifd matches its size %dx%d", lifd.ImageWidth, lifd.ImageLength)
+}
+
+// reducedFrom returns whether a w x h image can be an overview (or mask) of a topw x toph one,
+// i.e. both dimensions are reduced by the same integer factor, allowing for rounding
+func reducedFrom(w, h, topw, toph uint64) bool {
+	if w == 0 || h == 0 || w > topw || h > toph {
+		return false
+	}
+	f := uint64(math.Round(float64(topw) / float64(w)))
+	if f == 0 {
+		return false
+	}
+	near := func(a, b uint64) bool {
+		return a+1 >= b && b+1 >= a
+	}
+	return near((topw+f-1)/f, w) && near((toph+f-1)/f, h)
+}
+
+func loadIFD(r tiff.BReader, tifd tiff.IFD, isbigtiff bool, o *loadOptions) (*IFD, error) {
+	retile := o.retileWidth > 0 && tifd.HasField(273)
+	err := sanityCheckIFD(tifd, retile)
 	if err != nil {
 		return nil, err
 	}
@@ -65,6 +153,7 @@ func loadIFD(r tiff.BReader, tifd tiff.IFD, isbigtiff bool) (*IFD, error) {
 	if err != nil {
 		return nil, err
 	}
+	ifd.ExtraTags = loadTags(tifd)
 	if len(ifd.TempTileByteCounts) > 0 {
 		ifd.TileByteCounts = make([]uint32, len(ifd.TempTileByteCounts))
 		for i := range ifd.TempTileByteCounts {
@@ -72,10 +161,15 @@ func loadIFD(r tiff.BReader, tifd tiff.IFD, isbigtiff bool) (*IFD, error) {
 		}
 		ifd.TempTileByteCounts = nil //reclaim mem
 	}
+	if retile {
+		if err := ifd.retile(tifd, o.retileWidth, o.retileLength); err != nil {
+			return nil, fmt.Errorf("retile: %w", err)
+		}
+	}
 	if len(ifd.SubIFDOffsets) > 0 {
 		ifd.SubIFDs = make([]*IFD, len(ifd.SubIFDOffsets))
 		for s, soff := range ifd.SubIFDOffsets {
-			ifd.SubIFDs[s], err = loadOffset(r, soff, isbigtiff)
+			ifd.SubIFDs[s], err = loadOffset(r, soff, isbigtiff, o)
 			if err != nil {
 				return nil, fmt.Errorf("load offset %d/%d: %w", soff, s, err)
 			}
@@ -88,7 +182,7 @@ func loadIFD(r tiff.BReader, tifd tiff.IFD, isbigtiff bool) (*IFD, error) {
 	return ifd, nil
 }
 
-func loadOffset(r tiff.BReader, off uint64, isbigtiff bool) (*IFD, error) {
+func loadOffset(r tiff.BReader, off uint64, isbigtiff bool, o *loadOptions) (*IFD, error) {
 	var ifd tiff.IFD
 	var err error
 	if isbigtiff {
@@ -99,10 +193,25 @@ func loadOffset(r tiff.BReader, off uint64, isbigtiff bool) (*IFD, error) {
 	if err != nil {
 		return nil, err
 	}
-	return loadIFD(r, ifd, isbigtiff)
+	return loadIFD(r, ifd, isbigtiff, o)
 }
 
-func sanityCheckIFD(ifd tiff.IFD) error {
+// sanityCheckIFD checks the tiles of ifd, or its strips if they are to be retiled
+func sanityCheckIFD(ifd tiff.IFD, retile bool) error {
+	if retile {
+		so := ifd.GetField(273)
+		sl := ifd.GetField(279)
+		if so == nil || sl == nil || so.Count() != sl.Count() {
+			return fmt.Errorf("inconsistent strip off/len count")
+		}
+		if ifd.HasField(324) || ifd.HasField(325) {
+			return fmt.Errorf("tif has both strips and tiles")
+		}
+		return nil
+	}
+	if ifd.HasField(273) || ifd.HasField(279) {
+		return fmt.Errorf("tif has strips, use RetileStrips")
+	}
 	to := ifd.GetField(324)
 	tl := ifd.GetField(325)
 	if to == nil || tl == nil {
@@ -111,10 +220,5 @@ func sanityCheckIFD(ifd tiff.IFD) error {
 	if to.Count() != tl.Count() {
 		return fmt.Errorf("inconsistent tile off/len count")
 	}
-	so := ifd.GetField(272)
-	sl := ifd.GetField(279)
-	if so != nil || sl != nil {
-		return fmt.Errorf("tif has strips")
-	}
 	return nil
 }
@@ -0,0 +1,123 @@
+package mucog
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/tiff"
+)
+
+// testLegacyEntries returns the fields of a tiled ifd, with a NewSubfileType if subfileType >= 0
+func testLegacyEntries(subfileType int, width, height uint32) []testEntry {
+	ntiles := int((width+15)/16) * int((height+15)/16)
+	offsets, counts := make([]uint32, ntiles), make([]uint32, ntiles)
+	for i := range offsets {
+		offsets[i], counts[i] = 8, 1
+	}
+	var entries []testEntry
+	if subfileType >= 0 {
+		entries = append(entries, testEntry{254, TLong, []uint32{uint32(subfileType)}})
+	}
+	return append(entries, []testEntry{
+		{256, TLong, []uint32{width}},
+		{257, TLong, []uint32{height}},
+		{258, TShort, []uint32{8}},
+		{259, TShort, []uint32{1}},
+		{262, TShort, []uint32{PhotometricInterpretationMinIsBlack}},
+		{277, TShort, []uint32{1}},
+		{284, TShort, []uint32{PlanarConfigurationContig}},
+		{322, TShort, []uint32{16}},
+		{323, TShort, []uint32{16}},
+		{324, TLong, offsets},
+		{325, TLong, counts},
+	}...)
+}
+
+func testLoadLegacy(t *testing.T, ifds ...[]testEntry) ([]*IFD, error) {
+	tif, err := tiff.Parse(bytes.NewReader(testTIFFFile([]byte{0}, ifds...)), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return LoadTIFF(tif)
+}
+
+func TestLoadLegacyPages(t *testing.T) {
+	mifds, err := testLoadLegacy(t,
+		testLegacyEntries(-1, 64, 64),
+		testLegacyEntries(SubfileTypeReducedImage, 32, 32),
+		testLegacyEntries(SubfileTypeReducedImage, 16, 16),
+		testLegacyEntries(SubfileTypeMask, 64, 64),
+		testLegacyEntries(SubfileTypePage, 45, 45),
+		testLegacyEntries(SubfileTypeReducedImage, 23, 23),
+		testLegacyEntries(SubfileTypeReducedImage|SubfileTypeMask, 23, 23),
+		testLegacyEntries(-1, 64, 64),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := [][]uint32{
+		{SubfileTypeReducedImage, SubfileTypeReducedImage, SubfileTypeMask},
+		{SubfileTypeReducedImage, SubfileTypeReducedImage | SubfileTypeMask},
+		{},
+	}
+	if len(mifds) != len(expected) {
+		t.Fatalf("got %d images", len(mifds))
+	}
+	for i, mifd := range mifds {
+		if len(mifd.SubIFDs) != len(expected[i]) {
+			t.Fatalf("image %d: got %d subifds", i, len(mifd.SubIFDs))
+		}
+		for s, sifd := range mifd.SubIFDs {
+			if sifd.SubfileType != expected[i][s] || !reducedFrom(sifd.ImageWidth, sifd.ImageLength, mifd.ImageWidth, mifd.ImageLength) {
+				t.Errorf("image %d subifd %d: got %dx%d subfiletype %d", i, s, sifd.ImageWidth, sifd.ImageLength, sifd.SubfileType)
+			}
+		}
+	}
+
+	// all the pages followed by all the overviews
+	if _, err := testLoadLegacy(t,
+		testLegacyEntries(-1, 64, 64),
+		testLegacyEntries(-1, 64, 64),
+		testLegacyEntries(SubfileTypeReducedImage, 32, 32),
+		testLegacyEntries(SubfileTypeReducedImage, 32, 32),
+	); err == nil {
+		t.Error("expected ambiguous parent")
+	}
+	if _, err := testLoadLegacy(t,
+		testLegacyEntries(-1, 64, 64),
+		testLegacyEntries(-1, 64, 64),
+		testLegacyEntries(SubfileTypeReducedImage, 32, 16),
+	); err == nil {
+		t.Error("expected no matching parent")
+	}
+	// a single image holds all the legacy ifds, whatever their size
+	mifds, err = testLoadLegacy(t,
+		testLegacyEntries(SubfileTypeReducedImage, 32, 16),
+		testLegacyEntries(-1, 64, 64),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mifds) != 1 || len(mifds[0].SubIFDs) != 1 {
+		t.Errorf("got %d images", len(mifds))
+	}
+}
+
+func TestReducedFrom(t *testing.T) {
+	for _, tc := range []struct {
+		w, h, topw, toph uint64
+		reduced          bool
+	}{
+		{64, 64, 64, 64, true},
+		{32, 16, 64, 32, true},
+		{23, 12, 45, 23, true},
+		{500, 333, 1001, 667, true},
+		{32, 32, 64, 128, false},
+		{65, 64, 64, 64, false},
+		{0, 64, 64, 64, false},
+	} {
+		if reducedFrom(tc.w, tc.h, tc.topw, tc.toph) != tc.reduced {
+			t.Errorf("%dx%d from %dx%d: expected %v", tc.w, tc.h, tc.topw, tc.toph, tc.reduced)
+		}
+	}
+}
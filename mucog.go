@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"math"
+	"math/big"
 	"sort"
 
 	"github.com/google/tiff"
@@ -98,10 +99,13 @@ type IFD struct {
 	NewTileOffsets32          []uint32
 	TempTileByteCounts        []uint64 `tiff:"field,tag=325"`
 	TileByteCounts            []uint32
-	SubIFDOffsets             []uint64 `tiff:"field,tag=330"`
-	ExtraSamples              []uint16 `tiff:"field,tag=338"`
-	SampleFormat              []uint16 `tiff:"field,tag=339"`
-	JPEGTables                []byte   `tiff:"field,tag=347"`
+	SubIFDOffsets             []uint64   `tiff:"field,tag=330"`
+	ExtraSamples              []uint16   `tiff:"field,tag=338"`
+	SampleFormat              []uint16   `tiff:"field,tag=339"`
+	JPEGTables                []byte     `tiff:"field,tag=347"`
+	YCbCrSubsampling          []uint16   `tiff:"field,tag=530"`
+	YCbCrPositioning          uint16     `tiff:"field,tag=531"`
+	ReferenceBlackWhite       []*big.Rat `tiff:"field,tag=532"`
 
 	ModelPixelScaleTag     []float64 `tiff:"field,tag=33550"`
 	ModelTiePointTag       []float64 `tiff:"field,tag=33922"`
@@ -115,6 +119,9 @@ type IFD struct {
 
 	NoData string `tiff:"field,tag=42113"`
 
+	// ExtraTags are the tags not listed above, copied verbatim to the output
+	ExtraTags []Tag
+
 	SubIFDs    []*IFD
 	zoomFactor float64
 	ZoomLevel  int
@@ -122,11 +129,16 @@ type IFD struct {
 	ntags                  uint64
 	tagsSize               uint64
 	strileSize             uint64
+	shortByteCounts        bool   //TileByteCounts are written as SHORT
+	swapSize               int    //size of the samples to byte-swap when copying tiles, 0 if no swap is needed
 	nplanes                uint64 //1 if PlanarConfiguration==1, SamplesPerPixel if PlanarConfiguration==2
 	ntilesx, ntilesy       uint64
 	minx, miny, maxx, maxy uint64
 	r                      tiff.BReader
+	src                    io.ReaderAt //reader r was created from, if known
+	retiled                bool        //r holds tiles converted from the strips of the input
 	gt                     geotransform
+	tags                   []Tag //ExtraTags that are written to the output
 }
 
 /*
@@ -218,7 +230,7 @@ func (ifd *IFD) structure(bigtiff bool) (tagCount, ifdSize, strileSize, planeCou
 	}
 	if len(ifd.Colormap) > 0 {
 		tagCount++
-		ifdSize += arrayFieldSize(ifd.BitsPerSample, bigtiff)
+		ifdSize += arrayFieldSize(ifd.Colormap, bigtiff)
 	}
 	if ifd.TileWidth > 0 {
 		tagCount++
@@ -240,15 +252,19 @@ func (ifd *IFD) structure(bigtiff bool) (tagCount, ifdSize, strileSize, planeCou
 	if len(ifd.TileByteCounts) > 0 {
 		tagCount++
 		ifdSize += tagSize
-		strileSize += arrayFieldSize(ifd.TileByteCounts, bigtiff) - tagSize
+		if ifd.shortByteCounts {
+			strileSize += arrayFieldSize(make([]uint16, len(ifd.TileByteCounts)), bigtiff) - tagSize
+		} else {
+			strileSize += arrayFieldSize(ifd.TileByteCounts, bigtiff) - tagSize
+		}
 	}
 	if len(ifd.SubIFDOffsets) > 0 {
-		offs := make([]uint32, len(ifd.SubIFDOffsets))
-		for i := range offs {
-			offs[i] = uint32(ifd.SubIFDOffsets[i])
-		}
 		tagCount++
-		ifdSize += arrayFieldSize(offs, bigtiff)
+		if bigtiff {
+			ifdSize += arrayFieldSize(ifd8(ifd.SubIFDOffsets), bigtiff)
+		} else {
+			ifdSize += arrayFieldSize(make([]uint32, len(ifd.SubIFDOffsets)), bigtiff)
+		}
 	}
 	if len(ifd.ExtraSamples) > 0 {
 		tagCount++
@@ -262,6 +278,18 @@ func (ifd *IFD) structure(bigtiff bool) (tagCount, ifdSize, strileSize, planeCou
 		tagCount++
 		ifdSize += arrayFieldSize(ifd.JPEGTables, bigtiff)
 	}
+	if len(ifd.YCbCrSubsampling) > 0 {
+		tagCount++
+		ifdSize += arrayFieldSize(ifd.YCbCrSubsampling, bigtiff)
+	}
+	if ifd.YCbCrPositioning > 0 {
+		tagCount++
+		ifdSize += tagSize
+	}
+	if len(ifd.ReferenceBlackWhite) > 0 {
+		tagCount++
+		ifdSize += arrayFieldSize(ifd.ReferenceBlackWhite, bigtiff)
+	}
 	if len(ifd.ModelPixelScaleTag) > 0 {
 		tagCount++
 		ifdSize += arrayFieldSize(ifd.ModelPixelScaleTag, bigtiff)
@@ -302,6 +330,10 @@ func (ifd *IFD) structure(bigtiff bool) (tagCount, ifdSize, strileSize, planeCou
 		tagCount++
 		ifdSize += arrayFieldSize(ifd.NoData, bigtiff)
 	}
+	for _, tag := range ifd.tags {
+		tagCount++
+		ifdSize += arrayFieldSize(tag.Data, bigtiff)
+	}
 	return
 }
 
@@ -315,13 +347,45 @@ func (t *TagData) NextOffset() uint64 {
 }
 
 type MultiCOG struct {
-	enc       binary.ByteOrder
-	ifds      []*IFD
-	iterators []*Iterators
+	enc            binary.ByteOrder
+	ifds           []*IFD
+	iterators      []*Iterators
+	plan           *tilePlan
+	copyBufferSize int
+	keepTags       map[uint16]bool //if not nil, only these extra tags are written
+	dropTags       map[uint16]bool
+	sourceOrder    bool //tiles are written in the order of the sources rather than of the pattern
+	sidecar        bool //tile offsets are written relative to a separate data file
+	alignment      uint64
+	alignDepth     int
+	ghost          bool   //write a GDAL ghost area, and wrap the tiles with a leader and a trailer
+	ghostArea      []byte //content of the ghost area, written after the tiff header
+
+	normalizeRasterType bool
+	dataTypePolicy      Policy
+	tolerances          Tolerances
+	pixelSpace          bool        //align the images by pixel offsets rather than georeferencing
+	pixelOffsets        [][2]uint64 //pixel offsets of the top level ifds in pixel space
+	onCheck             func(Report)
 }
 
-func New() *MultiCOG {
-	return &MultiCOG{enc: binary.LittleEndian}
+// Option configures a MultiCOG
+type Option func(cog *MultiCOG)
+
+// ByteOrder sets the byte order of the output file (default: binary.LittleEndian).
+// Uncompressed tiles of inputs with a different byte order are byte-swapped when copied.
+func ByteOrder(enc binary.ByteOrder) Option {
+	return func(cog *MultiCOG) {
+		cog.enc = normalizeByteOrder(enc)
+	}
+}
+
+func New(opts ...Option) *MultiCOG {
+	cog := &MultiCOG{enc: binary.LittleEndian, tolerances: DefaultTolerances()}
+	for _, opt := range opts {
+		opt(cog)
+	}
+	return cog
 }
 
 func (cog *MultiCOG) writeHeader(w io.Writer, bigtiff bool) error {
@@ -335,9 +399,10 @@ func (cog *MultiCOG) writeHeader(w io.Writer, bigtiff bool) error {
 		cog.enc.PutUint16(buf[2:], 43)
 		cog.enc.PutUint16(buf[4:], 8)
 		cog.enc.PutUint16(buf[6:], 0)
-		cog.enc.PutUint64(buf[8:], 16)
-		_, err := w.Write(buf[:])
-		return err
+		cog.enc.PutUint64(buf[8:], cog.firstIFDOffset(bigtiff))
+		if _, err := w.Write(buf[:]); err != nil {
+			return err
+		}
 	} else {
 		buf := [8]byte{}
 		if cog.enc == binary.LittleEndian {
@@ -346,10 +411,21 @@ func (cog *MultiCOG) writeHeader(w io.Writer, bigtiff bool) error {
 			copy(buf[0:], []byte("MM"))
 		}
 		cog.enc.PutUint16(buf[2:], 42)
-		cog.enc.PutUint32(buf[4:], 8)
-		_, err := w.Write(buf[:])
-		return err
+		cog.enc.PutUint32(buf[4:], uint32(cog.firstIFDOffset(bigtiff)))
+		if _, err := w.Write(buf[:]); err != nil {
+			return err
+		}
+	}
+	_, err := w.Write(cog.ghostArea)
+	return err
+}
+
+// firstIFDOffset returns the offset of the first IFD, that follows the tiff header and the ghost area
+func (cog *MultiCOG) firstIFDOffset(bigtiff bool) uint64 {
+	if bigtiff {
+		return 16 + uint64(len(cog.ghostArea))
 	}
+	return 8 + uint64(len(cog.ghostArea))
 }
 
 const (
@@ -371,83 +447,45 @@ const (
 )
 
 func (cog *MultiCOG) computeStructure(bigtiff bool) error {
-	// Compute geotransform
-	var err error
-	for i, ifd := range cog.ifds {
-		if ifd.gt, err = ifd.geotransform(); err != nil {
-			return fmt.Errorf("ifd %d geotransform: %w", i, err)
-		}
-	}
-
-	// Validation
-	sx, sy := cog.ifds[0].gt.Scale()
-	tsx, tsy := cog.ifds[0].TileWidth, cog.ifds[0].TileLength
-	toPix, err := cog.ifds[0].gt.Inverse()
-	if err != nil {
+	// Validate the ifds and compute their geotransforms
+	report := cog.check()
+	if err := report.Err(); err != nil {
 		return err
 	}
-	if tsx != tsy {
-		return fmt.Errorf("non square tile size %dx%d", tsx, tsy)
+	if cog.onCheck != nil {
+		cog.onCheck(report)
 	}
+	if err := cog.normalize(); err != nil {
+		return err
+	}
+	gts := make([]geotransform, len(cog.ifds))
 	for i, ifd := range cog.ifds {
-		isx, isy := ifd.gt.Scale()
-		if math.Abs(1-isx/sx) > 0.00000001 || math.Abs(1-isy/sy) > 0.00000001 {
-			return fmt.Errorf("ifd %d incompatible scales (x: %.16f/%.16f, y: %.16f/%.16f)", i, isx, sx, isy, sy)
-		}
-		if ifd.TileWidth != tsx || ifd.TileLength != tsy {
-			return fmt.Errorf("ifd %d incompatible tile size (sx: %d/%d, sy: %d/%d)", i,
-				ifd.TileWidth, tsx, ifd.TileLength, tsy)
-		}
-		if ifd.nplanes != cog.ifds[0].nplanes {
-			return fmt.Errorf("ifd %d incompatible number of planes (%d/%d)", i, ifd.nplanes, cog.ifds[0].nplanes)
-		}
+		gts[i] = ifd.gt
 	}
+	tsx, tsy := cog.ifds[0].TileWidth, cog.ifds[0].TileLength
+	toPix := gridTransform(gts, nil)
 
-	// Get origin
-	ox, oy := cog.ifds[0].gt.Origin()
 	for _, ifd := range cog.ifds {
-		nox, noy := ifd.gt.Origin()
-		x, y := toPix.Transform(nox, noy)
-		if x < 0 {
-			ox = nox
-			toPix, _ = geotransform{ox, sx, 0, oy, 0, sy}.Inverse()
-		}
-		if y < 0 {
-			oy = noy
-			toPix, _ = geotransform{ox, sx, 0, oy, 0, sy}.Inverse()
-		}
-	}
-	/*
-		if math.Abs(math.Abs(sx)-math.Abs(sy)) > 0.0000000001 {
-			return fmt.Errorf("non square pixel scale %gx%g", sx, sy)
-		}
-	*/
-
-	for i, ifd := range cog.ifds {
+		ifd.tags = cog.filterTags(ifd)
 		ifd.ntags, ifd.tagsSize, ifd.strileSize, ifd.nplanes = ifd.structure(bigtiff)
 		ifd.ntilesx = (ifd.ImageWidth + uint64(ifd.TileWidth) - 1) / uint64(ifd.TileWidth)
 		ifd.ntilesy = (ifd.ImageLength + uint64(ifd.TileLength) - 1) / uint64(ifd.TileLength)
 
 		//pixel offset from origin of mucog
 		noffx, noffy := toPix.Transform(ifd.gt.Origin())
-
-		//check we have no more than .1 pixel grid mis-alignment
-		npx, npy := math.Mod(noffx, float64(tsx)), math.Mod(noffy, float64(tsy))
-		if !(npx < 0.1 || npx > (float64(tsx)-0.1)) ||
-			!(npy < 0.1 || npy > (float64(tsy)-0.1)) {
-			return fmt.Errorf("ifd %d invalid grid alignment %f/%f", i, npx, npy)
-		}
 		ifd.minx = uint64(math.Round(noffx / float64(tsx)))
 		ifd.miny = uint64(math.Round(noffy / float64(tsy)))
 		ifd.maxx = ifd.minx + ifd.ntilesx
 		ifd.maxy = ifd.miny + ifd.ntilesy
 
 		for _, sifd := range ifd.SubIFDs {
+			sifd.tags = cog.filterTags(sifd)
 			sifd.ntags, sifd.tagsSize, sifd.strileSize, sifd.nplanes = sifd.structure(bigtiff)
 			sifd.ntilesx = (sifd.ImageWidth + uint64(sifd.TileWidth) - 1) / uint64(sifd.TileWidth)
 			sifd.ntilesy = (sifd.ImageLength + uint64(sifd.TileLength) - 1) / uint64(sifd.TileLength)
-			sifd.minx = (ifd.minx * uint64(sifd.ImageWidth)) / uint64(ifd.ImageWidth)
-			sifd.miny = (ifd.miny * uint64(sifd.ImageLength)) / uint64(ifd.ImageLength)
+			//pixel offset of the overview, in overview tiles
+			sifd.minx = (ifd.minx * uint64(tsx) * sifd.ImageWidth) / (ifd.ImageWidth * uint64(sifd.TileWidth))
+			sifd.miny = (ifd.miny * uint64(tsy) * sifd.ImageLength) / (ifd.ImageLength * uint64(sifd.TileLength))
 			sifd.maxx, sifd.maxy = sifd.minx+sifd.ntilesx, sifd.miny+sifd.ntilesy
 			sifd.zoomFactor = math.Max(float64(ifd.ImageWidth)/float64(sifd.ImageWidth), float64(ifd.ImageLength)/float64(sifd.ImageLength))
 		}
@@ -517,20 +555,12 @@ func (cog *MultiCOG) AppendIFD(ifd *IFD) {
 
 func (cog *MultiCOG) computeImageryOffsets(bigtiff bool, pattern string) error {
 
+	// Start with the most compact strile arrays: 32bit offsets, and 16bit bytecounts when they fit.
+	// In bigtiff, an IFD is switched to 64bit offsets only if one of its tiles lies beyond 4GiB.
 	for _, mifd := range cog.ifds {
-		if bigtiff {
-			mifd.NewTileOffsets64 = make([]uint64, len(mifd.OriginalTileOffsets))
-		} else {
-			mifd.NewTileOffsets32 = make([]uint32, len(mifd.OriginalTileOffsets))
-		}
-		//mifd.NewTileOffsets = mifd.OriginalTileOffsets
+		mifd.initStriles()
 		for _, sc := range mifd.SubIFDs {
-			if bigtiff {
-				sc.NewTileOffsets64 = make([]uint64, len(sc.OriginalTileOffsets))
-			} else {
-				sc.NewTileOffsets32 = make([]uint32, len(sc.OriginalTileOffsets))
-			}
-			//sc.NewTileOffsets = sc.OriginalTileOffsets
+			sc.initStriles()
 		}
 	}
 	err := cog.computeStructure(bigtiff)
@@ -541,47 +571,95 @@ func (cog *MultiCOG) computeImageryOffsets(bigtiff bool, pattern string) error {
 	if err = cog.computeIterator(pattern); err != nil {
 		return err
 	}
-
-	//offset to start of image data
-	dataOffset := uint64(16)
-	if !bigtiff {
-		dataOffset = 8
+	cog.computePlan()
+	cog.ghostArea = nil
+	if cog.ghost {
+		cog.ghostArea = cog.plan.ghostArea(cog.alignment > 1)
+		cog.plan.frame = blockFrameSize
 	}
 
-	for _, mifd := range cog.ifds {
-		dataOffset += mifd.strileSize + mifd.tagsSize
-		for _, sc := range mifd.SubIFDs {
-			dataOffset += sc.strileSize + sc.tagsSize
+	// Growing an IFD's offsets to 64bit increases the header size, which in turn shifts all the
+	// tiles further: iterate until no more IFD needs to be grown. As IFDs are only ever grown,
+	// this converges in at most len(ifds) iterations.
+	for {
+		//offset to start of image data
+		dataOffset := cog.firstIFDOffset(bigtiff)
+
+		for _, mifd := range cog.ifds {
+			dataOffset += mifd.strileSize + mifd.tagsSize
+			for _, sc := range mifd.SubIFDs {
+				dataOffset += sc.strileSize + sc.tagsSize
+			}
+		}
+		cog.plan.dataOffset = dataOffset
+		//in sidecar mode, tile offsets are relative to the start of the data file, and are
+		//shifted by the padded size of the header when joined
+		base, shift := uint64(0), uint64(0)
+		if cog.sidecar {
+			base, shift = dataOffset, cog.sidecarShift()
 		}
-	}
 
-	datas := cog.dataInterlacing()
-	tiles := datas.Tiles(cog.iterators)
-	for tile := range tiles {
-		tileidx := (tile.x+tile.y*tile.ifd.ntilesx)*tile.ifd.nplanes + tile.plane
-		cnt := uint64(tile.ifd.TileByteCounts[tileidx])
-		if cnt > 0 {
-			if bigtiff {
-				tile.ifd.NewTileOffsets64[tileidx] = dataOffset
-			} else {
-				if dataOffset > uint64(^uint32(0)) { //^uint32(0) is max uint32
-					return fmt.Errorf("data would overflow tiff capacity, use bigtiff")
-				}
-				tile.ifd.NewTileOffsets32[tileidx] = uint32(dataOffset)
+		grown := map[*IFD]bool{}
+		for i := 0; i < cog.plan.Len(); i++ {
+			ifd, tileidx := cog.plan.IFD(i)
+			//the tile data follows its block leader, if any
+			dataOffset += cog.plan.frame
+			if cog.alignment > 1 && cog.plan.aligned(i) {
+				dataOffset = base + (dataOffset-base+cog.alignment-1)/cog.alignment*cog.alignment
 			}
-			dataOffset += uint64(tile.ifd.TileByteCounts[tileidx])
-		} else {
-			if bigtiff {
-				tile.ifd.NewTileOffsets64[tileidx] = 0
+			if len(ifd.NewTileOffsets32) > 0 {
+				if dataOffset-base+shift > uint64(^uint32(0)) { //^uint32(0) is max uint32
+					grown[ifd] = true
+				} else {
+					ifd.NewTileOffsets32[tileidx] = uint32(dataOffset - base)
+				}
 			} else {
-				tile.ifd.NewTileOffsets32[tileidx] = 0
+				ifd.NewTileOffsets64[tileidx] = dataOffset - base
 			}
+			cog.plan.offset[i] = dataOffset
+			dataOffset += uint64(cog.plan.size[i]) + cog.plan.frame
+		}
+		if len(grown) == 0 {
+			break
+		}
+		if !bigtiff {
+			return fmt.Errorf("data would overflow tiff capacity, use bigtiff")
+		}
+		for ifd := range grown {
+			ifd.NewTileOffsets64 = make([]uint64, len(ifd.NewTileOffsets32))
+			ifd.NewTileOffsets32 = nil
+			ifd.ntags, ifd.tagsSize, ifd.strileSize, _ = ifd.structure(bigtiff)
 		}
 	}
 
 	return nil
 }
 
+// initStriles resets the tile offsets to 32bit, and selects the type of the tile bytecounts
+func (ifd *IFD) initStriles() {
+	ifd.NewTileOffsets32 = make([]uint32, len(ifd.OriginalTileOffsets))
+	ifd.NewTileOffsets64 = nil
+	ifd.shortByteCounts = true
+	for _, cnt := range ifd.TileByteCounts {
+		if cnt > math.MaxUint16 {
+			ifd.shortByteCounts = false
+			break
+		}
+	}
+}
+
+// tileByteCounts returns the tile bytecounts in the type they must be written with
+func (ifd *IFD) tileByteCounts() interface{} {
+	if !ifd.shortByteCounts {
+		return ifd.TileByteCounts
+	}
+	counts := make([]uint16, len(ifd.TileByteCounts))
+	for i, cnt := range ifd.TileByteCounts {
+		counts[i] = uint16(cnt)
+	}
+	return counts
+}
+
 /** Write multiCOG to a mucog
  * Parameters "pattern" defines how to interlace the [I]mages (TopLevel IFD/dataset) the [P]lanes (bands), the [L]evel (zooms/overview/reduced image) and the [T]iles (geotiff blocks).
  *
@@ -618,6 +696,17 @@ func (cog *MultiCOG) computeImageryOffsets(bigtiff bool, pattern string) error {
  * There is no validation that the pattern includes all the tiles (the others will be lost, e.g. L=0>T>I>P removes all the overviews), neither that the pattern has duplicated tiles (unpredictable behavior: e.g. L>T>I>P=0;L>T>I>P=0:2 : P=0 is duplicated).
  */
 func (cog *MultiCOG) Write(out io.Writer, bigtiff bool, pattern string) error {
+	if err := cog.prepare(bigtiff, pattern); err != nil {
+		return err
+	}
+	if err := cog.writeHeaders(out, bigtiff); err != nil {
+		return err
+	}
+	return cog.copyTiles(out)
+}
+
+// prepare computes the layout of the mucog: the tile plan, and the offsets of the tiles and IFDs
+func (cog *MultiCOG) prepare(bigtiff bool, pattern string) error {
 	if len(cog.ifds) == 0 {
 		return fmt.Errorf("empty ifds")
 	}
@@ -636,31 +725,67 @@ func (cog *MultiCOG) Write(out io.Writer, bigtiff bool, pattern string) error {
 		return err
 	}
 
-	//compute start of strile data, and offsets to subIFDs
-	//striles are placed after all ifds
-	strileData := &TagData{Offset: 16}
-	if !bigtiff {
-		strileData.Offset = 8
-	}
-
+	//compute offsets to subIFDs, placed after all top level ifds
+	off := cog.firstIFDOffset(bigtiff)
 	for _, mifd := range cog.ifds {
-		strileData.Offset += mifd.tagsSize
+		off += mifd.tagsSize
 	}
 	for s := 0; s < maxSubIFDNb; s++ {
 		for _, mifd := range cog.ifds {
 			if s < len(mifd.SubIFDs) {
-				mifd.SubIFDOffsets[s] = strileData.Offset
-				strileData.Offset += mifd.SubIFDs[s].tagsSize
+				mifd.SubIFDOffsets[s] = off
+				off += mifd.SubIFDs[s].tagsSize
 			}
 		}
 	}
+	return nil
+}
 
-	cog.writeHeader(out, bigtiff)
+// headerSize returns the size of the header of the mucog (tiff header, IFDs and striles), i.e.
+// where the tile data starts. It is only valid once prepare has been called.
+func (cog *MultiCOG) headerSize(bigtiff bool) uint64 {
+	size := cog.firstIFDOffset(bigtiff)
+	for _, mifd := range cog.ifds {
+		size += mifd.tagsSize + mifd.strileSize
+		for _, sifd := range mifd.SubIFDs {
+			size += sifd.tagsSize + sifd.strileSize
+		}
+	}
+	return size
+}
 
-	off := uint64(16)
-	if !bigtiff {
-		off = 8
+// size returns the size of the mucog. It is only valid once prepare has been called.
+func (cog *MultiCOG) size(bigtiff bool) uint64 {
+	if n := cog.plan.Len(); n > 0 {
+		return cog.plan.blockEnd(n - 1)
 	}
+	return cog.headerSize(bigtiff)
+}
+
+// writeHeaders writes the tiff header, the IFDs and the striles, i.e. everything but the tiles.
+// The layout must have been computed by prepare.
+func (cog *MultiCOG) writeHeaders(out io.Writer, bigtiff bool) error {
+	maxSubIFDNb := 0
+	for _, mifd := range cog.ifds {
+		if maxSubIFDNb < len(mifd.SubIFDs) {
+			maxSubIFDNb = len(mifd.SubIFDs)
+		}
+	}
+
+	//striles are placed after all ifds
+	strileData := &TagData{Offset: cog.firstIFDOffset(bigtiff)}
+	for _, mifd := range cog.ifds {
+		strileData.Offset += mifd.tagsSize
+		for _, sifd := range mifd.SubIFDs {
+			strileData.Offset += sifd.tagsSize
+		}
+	}
+
+	if err := cog.writeHeader(out, bigtiff); err != nil {
+		return fmt.Errorf("write header: %w", err)
+	}
+
+	off := cog.firstIFDOffset(bigtiff)
 	// Add full resolution IFDs
 	for i, mifd := range cog.ifds {
 		//compute offset of next top level ifd
@@ -669,7 +794,6 @@ func (cog *MultiCOG) Write(out io.Writer, bigtiff bool, pattern string) error {
 		if i != len(cog.ifds)-1 {
 			next = off + mifd.tagsSize
 		}
-		//log.Printf("%d offsets: %v", i, mifd.NewTileOffsets)
 		err := cog.writeIFD(out, bigtiff, mifd, off, strileData, next)
 		if err != nil {
 			return fmt.Errorf("write ifd %d: %w", i, err)
@@ -689,29 +813,12 @@ func (cog *MultiCOG) Write(out io.Writer, bigtiff bool, pattern string) error {
 		}
 	}
 
-	//write all subifds
-	_, err = out.Write(strileData.Bytes())
-
-	datas := cog.dataInterlacing()
-	tiles := datas.Tiles(cog.iterators)
-	buf := &bytes.Buffer{}
-	for tile := range tiles {
-		buf.Reset()
-		idx := (tile.x+tile.y*tile.ifd.ntilesx)*tile.ifd.nplanes + tile.plane
-		if tile.ifd.TileByteCounts[idx] > 0 {
-			_, err := tile.ifd.r.Seek(int64(tile.ifd.OriginalTileOffsets[idx]), io.SeekStart)
-			if err != nil {
-				return fmt.Errorf("seek to %d: %w", tile.ifd.OriginalTileOffsets[idx], err)
-			}
-			_, err = io.CopyN(out, tile.ifd.r, int64(tile.ifd.TileByteCounts[idx]))
-			if err != nil {
-				return fmt.Errorf("copy %d from %d: %w",
-					tile.ifd.TileByteCounts[idx], tile.ifd.OriginalTileOffsets[idx], err)
-			}
-		}
+	//write all striles
+	_, err := out.Write(strileData.Bytes())
+	if err != nil {
+		return fmt.Errorf("write striles: %w", err)
 	}
-
-	return err
+	return nil
 }
 
 func (cog *MultiCOG) writeIFD(w io.Writer, bigtiff bool, ifd *IFD, offset uint64, striledata *TagData, next uint64) error {
@@ -735,241 +842,287 @@ func (cog *MultiCOG) writeIFD(w io.Writer, bigtiff bool, ifd *IFD, offset uint64
 		return fmt.Errorf("write header: %w", err)
 	}
 
+	// Entries are buffered to be sorted by tag number once the extra tags are added
+	entries := &bytes.Buffer{}
+
 	if ifd.SubfileType > 0 {
-		err := cog.writeField(w, bigtiff, 254, ifd.SubfileType)
+		err := cog.writeField(entries, bigtiff, 254, ifd.SubfileType)
 		if err != nil {
-			panic(err)
+			return fmt.Errorf("write SubfileType: %w", err)
 		}
 	}
 	if ifd.ImageWidth > 0 {
-		err := cog.writeField(w, bigtiff, 256, uint32(ifd.ImageWidth))
+		err := cog.writeField(entries, bigtiff, 256, uint32(ifd.ImageWidth))
 		if err != nil {
-			panic(err)
+			return fmt.Errorf("write ImageWidth: %w", err)
 		}
 	}
 	if ifd.ImageLength > 0 {
-		err := cog.writeField(w, bigtiff, 257, uint32(ifd.ImageLength))
+		err := cog.writeField(entries, bigtiff, 257, uint32(ifd.ImageLength))
 		if err != nil {
-			panic(err)
+			return fmt.Errorf("write ImageLength: %w", err)
 		}
 	}
 
 	if len(ifd.BitsPerSample) > 0 {
-		err := cog.writeArray(w, bigtiff, 258, ifd.BitsPerSample, overflow)
+		err := cog.writeArray(entries, bigtiff, 258, ifd.BitsPerSample, overflow)
 		if err != nil {
-			panic(err)
+			return fmt.Errorf("write BitsPerSample: %w", err)
 		}
 	}
 
 	if ifd.Compression > 0 {
-		err := cog.writeField(w, bigtiff, 259, ifd.Compression)
+		err := cog.writeField(entries, bigtiff, 259, ifd.Compression)
 		if err != nil {
-			panic(err)
+			return fmt.Errorf("write Compression: %w", err)
 		}
 	}
 
-	err = cog.writeField(w, bigtiff, 262, ifd.PhotometricInterpretation)
+	err = cog.writeField(entries, bigtiff, 262, ifd.PhotometricInterpretation)
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("write PhotometricInterpretation: %w", err)
 	}
 
 	//DocumentName              string   `tiff:"field,tag=269"`
 	if len(ifd.DocumentName) > 0 {
-		err := cog.writeArray(w, bigtiff, 269, ifd.DocumentName, overflow)
+		err := cog.writeArray(entries, bigtiff, 269, ifd.DocumentName, overflow)
 		if err != nil {
-			panic(err)
+			return fmt.Errorf("write DocumentName: %w", err)
 		}
 	}
 
 	//SamplesPerPixel           uint16   `tiff:"field,tag=277"`
 	if ifd.SamplesPerPixel > 0 {
-		err := cog.writeField(w, bigtiff, 277, ifd.SamplesPerPixel)
+		err := cog.writeField(entries, bigtiff, 277, ifd.SamplesPerPixel)
 		if err != nil {
-			panic(err)
+			return fmt.Errorf("write SamplesPerPixel: %w", err)
 		}
 	}
 
 	//PlanarConfiguration       uint16   `tiff:"field,tag=284"`
 	if ifd.PlanarConfiguration > 0 {
-		err := cog.writeField(w, bigtiff, 284, ifd.PlanarConfiguration)
+		err := cog.writeField(entries, bigtiff, 284, ifd.PlanarConfiguration)
 		if err != nil {
-			panic(err)
+			return fmt.Errorf("write PlanarConfiguration: %w", err)
 		}
 	}
 
 	//DateTime                  string   `tiff:"field,tag=306"`
 	if len(ifd.DateTime) > 0 {
-		err := cog.writeArray(w, bigtiff, 306, ifd.DateTime, overflow)
+		err := cog.writeArray(entries, bigtiff, 306, ifd.DateTime, overflow)
 		if err != nil {
-			panic(err)
+			return fmt.Errorf("write DateTime: %w", err)
 		}
 	}
 
 	//Predictor                 uint16   `tiff:"field,tag=317"`
 	if ifd.Predictor > 0 {
-		err := cog.writeField(w, bigtiff, 317, ifd.Predictor)
+		err := cog.writeField(entries, bigtiff, 317, ifd.Predictor)
 		if err != nil {
-			panic(err)
+			return fmt.Errorf("write Predictor: %w", err)
 		}
 	}
 
 	//Colormap                  []uint16 `tiff:"field,tag=320"`
 	if len(ifd.Colormap) > 0 {
-		err := cog.writeArray(w, bigtiff, 320, ifd.Colormap, overflow)
+		err := cog.writeArray(entries, bigtiff, 320, ifd.Colormap, overflow)
 		if err != nil {
-			panic(err)
+			return fmt.Errorf("write Colormap: %w", err)
 		}
 	}
 
 	//TileWidth                 uint16   `tiff:"field,tag=322"`
 	if ifd.TileWidth > 0 {
-		err := cog.writeField(w, bigtiff, 322, ifd.TileWidth)
+		err := cog.writeField(entries, bigtiff, 322, ifd.TileWidth)
 		if err != nil {
-			panic(err)
+			return fmt.Errorf("write TileWidth: %w", err)
 		}
 	}
 
 	//TileHeight                uint16   `tiff:"field,tag=323"`
 	if ifd.TileLength > 0 {
-		err := cog.writeField(w, bigtiff, 323, ifd.TileLength)
+		err := cog.writeField(entries, bigtiff, 323, ifd.TileLength)
 		if err != nil {
-			panic(err)
+			return fmt.Errorf("write TileLength: %w", err)
 		}
 	}
 
 	//TileOffsets               []uint64 `tiff:"field,tag=324"`
 	if len(ifd.NewTileOffsets32) > 0 {
-		err := cog.writeArray(w, bigtiff, 324, ifd.NewTileOffsets32, striledata)
+		err := cog.writeArray(entries, bigtiff, 324, ifd.NewTileOffsets32, striledata)
 		if err != nil {
-			panic(err)
+			return fmt.Errorf("write TileOffsets: %w", err)
 		}
 	} else {
-		err := cog.writeArray(w, bigtiff, 324, ifd.NewTileOffsets64, striledata)
+		err := cog.writeArray(entries, bigtiff, 324, ifd.NewTileOffsets64, striledata)
 		if err != nil {
-			panic(err)
+			return fmt.Errorf("write TileOffsets: %w", err)
 		}
 	}
 
 	//TileByteCounts            []uint32 `tiff:"field,tag=325"`
 	if len(ifd.TileByteCounts) > 0 {
-		err := cog.writeArray(w, bigtiff, 325, ifd.TileByteCounts, striledata)
+		err := cog.writeArray(entries, bigtiff, 325, ifd.tileByteCounts(), striledata)
 		if err != nil {
-			panic(err)
+			return fmt.Errorf("write TileByteCounts: %w", err)
 		}
 	}
 
 	//SubIFDOffsets             []uint64 `tiff:"field,tag=330"`
 	if len(ifd.SubIFDOffsets) > 0 {
-		offs := make([]uint32, len(ifd.SubIFDOffsets))
-		for i := range offs {
-			if ifd.SubIFDOffsets[i] > uint64(^uint32(0)) {
-				panic("subifdoffset too big")
+		if bigtiff {
+			err := cog.writeArray(entries, bigtiff, 330, ifd8(ifd.SubIFDOffsets), overflow)
+			if err != nil {
+				return fmt.Errorf("write SubIFDOffsets: %w", err)
+			}
+		} else {
+			offs := make([]uint32, len(ifd.SubIFDOffsets))
+			for i := range offs {
+				if ifd.SubIFDOffsets[i] > uint64(^uint32(0)) {
+					return fmt.Errorf("subifd offset %d would overflow tiff capacity, use bigtiff", ifd.SubIFDOffsets[i])
+				}
+				offs[i] = uint32(ifd.SubIFDOffsets[i])
+			}
+			err := cog.writeArray(entries, bigtiff, 330, offs, overflow)
+			if err != nil {
+				return fmt.Errorf("write SubIFDOffsets: %w", err)
 			}
-			offs[i] = uint32(ifd.SubIFDOffsets[i])
-		}
-		err := cog.writeArray(w, bigtiff, 330, offs, overflow)
-		if err != nil {
-			panic(err)
 		}
 	}
 
 	//ExtraSamples              []uint16 `tiff:"field,tag=338"`
 	if len(ifd.ExtraSamples) > 0 {
-		err := cog.writeArray(w, bigtiff, 338, ifd.ExtraSamples, overflow)
+		err := cog.writeArray(entries, bigtiff, 338, ifd.ExtraSamples, overflow)
 		if err != nil {
-			panic(err)
+			return fmt.Errorf("write ExtraSamples: %w", err)
 		}
 	}
 
 	//SampleFormat              []uint16 `tiff:"field,tag=339"`
 	if len(ifd.SampleFormat) > 0 {
-		err := cog.writeArray(w, bigtiff, 339, ifd.SampleFormat, overflow)
+		err := cog.writeArray(entries, bigtiff, 339, ifd.SampleFormat, overflow)
 		if err != nil {
-			panic(err)
+			return fmt.Errorf("write SampleFormat: %w", err)
 		}
 	}
 
 	//JPEGTables                []byte   `tiff:"field,tag=347"`
 	if len(ifd.JPEGTables) > 0 {
-		err := cog.writeArray(w, bigtiff, 347, ifd.JPEGTables, overflow)
+		err := cog.writeArray(entries, bigtiff, 347, ifd.JPEGTables, overflow)
+		if err != nil {
+			return fmt.Errorf("write JPEGTables: %w", err)
+		}
+	}
+
+	//YCbCrSubsampling          []uint16   `tiff:"field,tag=530"`
+	if len(ifd.YCbCrSubsampling) > 0 {
+		err := cog.writeArray(entries, bigtiff, 530, ifd.YCbCrSubsampling, overflow)
+		if err != nil {
+			return fmt.Errorf("write YCbCrSubsampling: %w", err)
+		}
+	}
+
+	//YCbCrPositioning          uint16     `tiff:"field,tag=531"`
+	if ifd.YCbCrPositioning > 0 {
+		err := cog.writeField(entries, bigtiff, 531, ifd.YCbCrPositioning)
 		if err != nil {
-			panic(err)
+			return fmt.Errorf("write YCbCrPositioning: %w", err)
+		}
+	}
+
+	//ReferenceBlackWhite       []*big.Rat `tiff:"field,tag=532"`
+	if len(ifd.ReferenceBlackWhite) > 0 {
+		err := cog.writeArray(entries, bigtiff, 532, ifd.ReferenceBlackWhite, overflow)
+		if err != nil {
+			return fmt.Errorf("write ReferenceBlackWhite: %w", err)
 		}
 	}
 
 	//ModelPixelScaleTag     []float64 `tiff:"field,tag=33550"`
 	if len(ifd.ModelPixelScaleTag) > 0 {
-		err := cog.writeArray(w, bigtiff, 33550, ifd.ModelPixelScaleTag, overflow)
+		err := cog.writeArray(entries, bigtiff, 33550, ifd.ModelPixelScaleTag, overflow)
 		if err != nil {
-			panic(err)
+			return fmt.Errorf("write ModelPixelScaleTag: %w", err)
 		}
 	}
 
 	//ModelTiePointTag       []float64 `tiff:"field,tag=33922"`
 	if len(ifd.ModelTiePointTag) > 0 {
-		err := cog.writeArray(w, bigtiff, 33922, ifd.ModelTiePointTag, overflow)
+		err := cog.writeArray(entries, bigtiff, 33922, ifd.ModelTiePointTag, overflow)
 		if err != nil {
-			panic(err)
+			return fmt.Errorf("write ModelTiePointTag: %w", err)
 		}
 	}
 
 	//ModelTransformationTag []float64 `tiff:"field,tag=34264"`
 	if len(ifd.ModelTransformationTag) > 0 {
-		err := cog.writeArray(w, bigtiff, 34264, ifd.ModelTransformationTag, overflow)
+		err := cog.writeArray(entries, bigtiff, 34264, ifd.ModelTransformationTag, overflow)
 		if err != nil {
-			panic(err)
+			return fmt.Errorf("write ModelTransformationTag: %w", err)
 		}
 	}
 
 	//GeoKeyDirectoryTag     []uint16  `tiff:"field,tag=34735"`
 	if len(ifd.GeoKeyDirectoryTag) > 0 {
-		err := cog.writeArray(w, bigtiff, 34735, ifd.GeoKeyDirectoryTag, overflow)
+		err := cog.writeArray(entries, bigtiff, 34735, ifd.GeoKeyDirectoryTag, overflow)
 		if err != nil {
-			panic(err)
+			return fmt.Errorf("write GeoKeyDirectoryTag: %w", err)
 		}
 	}
 
 	//GeoDoubleParamsTag     []float64 `tiff:"field,tag=34736"`
 	if len(ifd.GeoDoubleParamsTag) > 0 {
-		err := cog.writeArray(w, bigtiff, 34736, ifd.GeoDoubleParamsTag, overflow)
+		err := cog.writeArray(entries, bigtiff, 34736, ifd.GeoDoubleParamsTag, overflow)
 		if err != nil {
-			panic(err)
+			return fmt.Errorf("write GeoDoubleParamsTag: %w", err)
 		}
 	}
 
 	//GeoAsciiParamsTag      string    `tiff:"field,tag=34737"`
 	if len(ifd.GeoAsciiParamsTag) > 0 {
-		err := cog.writeArray(w, bigtiff, 34737, ifd.GeoAsciiParamsTag, overflow)
+		err := cog.writeArray(entries, bigtiff, 34737, ifd.GeoAsciiParamsTag, overflow)
 		if err != nil {
-			panic(err)
+			return fmt.Errorf("write GeoAsciiParamsTag: %w", err)
 		}
 	}
 
 	if ifd.GDALMetaData != "" {
-		err := cog.writeArray(w, bigtiff, 42112, ifd.GDALMetaData, overflow)
+		err := cog.writeArray(entries, bigtiff, 42112, ifd.GDALMetaData, overflow)
 		if err != nil {
-			panic(err)
+			return fmt.Errorf("write GDALMetaData: %w", err)
 		}
 	}
 	//NoData string `tiff:"field,tag=42113"`
 	if len(ifd.NoData) > 0 {
-		err := cog.writeArray(w, bigtiff, 42113, ifd.NoData, overflow)
+		err := cog.writeArray(entries, bigtiff, 42113, ifd.NoData, overflow)
 		if err != nil {
-			panic(err)
+			return fmt.Errorf("write NoData: %w", err)
 		}
 	}
 	if len(ifd.LERCParams) > 0 {
-		err := cog.writeArray(w, bigtiff, 50674, ifd.LERCParams, overflow)
+		err := cog.writeArray(entries, bigtiff, 50674, ifd.LERCParams, overflow)
 		if err != nil {
-			panic(err)
+			return fmt.Errorf("write LERCParams: %w", err)
 		}
 	}
 	if len(ifd.RPCs) > 0 {
-		err := cog.writeArray(w, bigtiff, 50844, ifd.RPCs, overflow)
+		err := cog.writeArray(entries, bigtiff, 50844, ifd.RPCs, overflow)
+		if err != nil {
+			return fmt.Errorf("write RPCs: %w", err)
+		}
+	}
+
+	for _, tag := range ifd.tags {
+		err := cog.writeTag(entries, bigtiff, tag, overflow)
 		if err != nil {
-			panic(err)
+			return fmt.Errorf("write tag %d: %w", tag.ID, err)
 		}
 	}
+	cog.sortEntries(entries.Bytes(), bigtiff)
+	_, err = w.Write(entries.Bytes())
+	if err != nil {
+		return fmt.Errorf("write entries: %w", err)
+	}
 
 	if bigtiff {
 		err = binary.Write(w, cog.enc, next)
@@ -986,12 +1139,6 @@ func (cog *MultiCOG) writeIFD(w io.Writer, bigtiff bool, ifd *IFD, offset uint64
 	return nil
 }
 
-type tile struct {
-	ifd   *IFD
-	x, y  uint64
-	plane uint64
-}
-
 type datas [][][]*IFD
 
 func (cog *MultiCOG) dataInterlacing() datas {
@@ -1016,28 +1163,33 @@ func (cog *MultiCOG) dataInterlacing() datas {
 	return result
 }
 
-func (d datas) Tiles(iterators []*Iterators) chan tile {
-	ch := make(chan tile)
-	go func() {
-		defer close(ch)
-		for _, it := range iterators {
-			indices := []*int{nil, nil, nil, nil}
-			for it[0].Init(indices); it[0].Next(); {
-				for it[1].Init(indices); it[1].Next(); {
-					for it[2].Init(indices); it[2].Next(); {
-						for it[3].Init(indices); it[3].Next(); {
-							x, y := DecodePair(*indices[IDX_TILE])
-							p := uint64(*indices[IDX_PLANE])
-							if *indices[IDX_LEVEL] < len(d[*indices[IDX_IMAGE]]) {
-								for _, ifd := range d[*indices[IDX_IMAGE]][*indices[IDX_LEVEL]] {
-									if uint64(x) >= ifd.minx && uint64(x) < ifd.maxx && uint64(y) >= ifd.miny && uint64(y) < ifd.maxy {
-										ch <- tile{
-											ifd:   ifd,
-											x:     uint64(x) - ifd.minx,
-											y:     uint64(y) - ifd.miny,
-											plane: p,
-										}
-									}
+// walk calls fn for each tile of the interlacing pattern defined by iterators, in order. group is
+// incremented each time one of the depth outermost iterators of a pattern advances, and when
+// starting a new pattern of the chain.
+func (d datas) walk(iterators []*Iterators, depth int, fn func(ifd *IFD, x, y, plane uint64, group int)) {
+	group := 0
+	next := func(level int) {
+		if level == depth {
+			group++
+		}
+	}
+	for _, it := range iterators {
+		group++
+		indices := []*int{nil, nil, nil, nil}
+		for it[0].Init(indices); it[0].Next(); {
+			next(1)
+			for it[1].Init(indices); it[1].Next(); {
+				next(2)
+				for it[2].Init(indices); it[2].Next(); {
+					next(3)
+					for it[3].Init(indices); it[3].Next(); {
+						next(4)
+						x, y := DecodePair(*indices[IDX_TILE])
+						p := uint64(*indices[IDX_PLANE])
+						if *indices[IDX_LEVEL] < len(d[*indices[IDX_IMAGE]]) {
+							for _, ifd := range d[*indices[IDX_IMAGE]][*indices[IDX_LEVEL]] {
+								if uint64(x) >= ifd.minx && uint64(x) < ifd.maxx && uint64(y) >= ifd.miny && uint64(y) < ifd.maxy {
+									fn(ifd, uint64(x)-ifd.minx, uint64(y)-ifd.miny, p, group)
 								}
 							}
 						}
@@ -1045,6 +1197,5 @@ func (d datas) Tiles(iterators []*Iterators) chan tile {
 				}
 			}
 		}
-	}()
-	return ch
+	}
 }
@@ -259,3 +259,105 @@ func TestMucog(t *testing.T) {
 		t.Errorf("TestMucog.checkMucog: %v", err)
 	}
 }
+
+func generateJPEGData(fname string, size int, seed byte) ([]byte, error) {
+	ds, err := godal.Create(godal.GTiff, fname, 3, godal.Byte, size, size, godal.CreationOption(
+		"TILED=YES", "BLOCKXSIZE=64", "BLOCKYSIZE=64", "COMPRESS=JPEG", "PHOTOMETRIC=YCBCR",
+	))
+	if err != nil {
+		return nil, err
+	}
+	if err = ds.SetGeoTransform([6]float64{0, 1, 0, 0, 0, -1}); err != nil {
+		return nil, err
+	}
+	// Colored gradients, pixel interleaved
+	buf := make([]byte, 3*size*size)
+	for i := 0; i < size*size; i++ {
+		x, y := i%size, i/size
+		buf[3*i] = byte(x) + seed
+		buf[3*i+1] = byte(y) + seed
+		buf[3*i+2] = byte(x+y) + seed
+	}
+	if err = ds.Write(0, 0, buf, size, size); err != nil {
+		return nil, err
+	}
+	if err = ds.BuildOverviews(godal.Levels(2, 4)); err != nil {
+		return nil, err
+	}
+	if err = ds.Close(); err != nil {
+		return nil, err
+	}
+	return readRGB(fname, size)
+}
+
+func readRGB(fname string, size int) ([]byte, error) {
+	ds, err := godal.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer ds.Close()
+	buf := make([]byte, 3*size*size)
+	if err := ds.Read(0, 0, buf, size, size); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+func TestMucogJPEGYCbCr(t *testing.T) {
+	godal.RegisterAll()
+
+	size := 128
+	filePath1 := path.Join(os.TempDir(), "ycbcr1.tif")
+	filePath2 := path.Join(os.TempDir(), "ycbcr2.tif")
+	resultFilePath := path.Join(os.TempDir(), "mucog_ycbcr.tif")
+	defer func() {
+		os.RemoveAll(filePath1)
+		os.RemoveAll(filePath2)
+		os.RemoveAll(resultFilePath)
+	}()
+
+	data1, err := generateJPEGData(filePath1, size, 0)
+	if err != nil {
+		t.Fatalf("TestMucogJPEGYCbCr.generateJPEGData: %v", err)
+	}
+	data2, err := generateJPEGData(filePath2, size, 100)
+	if err != nil {
+		t.Fatalf("TestMucogJPEGYCbCr.generateJPEGData: %v", err)
+	}
+
+	file1, err := os.Open(filePath1)
+	if err != nil {
+		t.Fatalf("TestMucogJPEGYCbCr.Openfile1: %v", err)
+	}
+	defer file1.Close()
+	file2, err := os.Open(filePath2)
+	if err != nil {
+		t.Fatalf("TestMucogJPEGYCbCr.Openfile2: %v", err)
+	}
+	defer file2.Close()
+
+	multiCOG := mucog.New()
+	if err := loadMucog([]*os.File{file1, file2}, multiCOG); err != nil {
+		t.Fatalf("TestMucogJPEGYCbCr.loadMucog: %v", err)
+	}
+	out, err := os.Create(resultFilePath)
+	if err != nil {
+		t.Fatalf("TestMucogJPEGYCbCr.Create: %v", err)
+	}
+	if err = multiCOG.Write(out, false, mucog.MUCOGPattern); err != nil {
+		t.Fatalf("TestMucogJPEGYCbCr.Write: %v", err)
+	}
+	if err = out.Close(); err != nil {
+		t.Fatalf("TestMucogJPEGYCbCr.Close: %v", err)
+	}
+
+	for i, expected := range [][]byte{data1, data2} {
+		got, err := readRGB(fmt.Sprintf("GTIFF_DIR:%d:%s", i+1, resultFilePath), size)
+		if err != nil {
+			t.Fatalf("TestMucogJPEGYCbCr.readRGB: %v", err)
+		}
+		if !bytes.Equal(got, expected) {
+			t.Errorf("TestMucogJPEGYCbCr: image %d content mismatch", i+1)
+		}
+	}
+}
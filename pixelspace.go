@@ -0,0 +1,44 @@
+package mucog
+
+// PixelSpace aligns the images in pixel space instead of using their georeferencing, so that non
+// georeferenced or RPC only images (e.g. co-registered raw acquisitions) can be assembled.
+// offsets are the pixel offsets (column, row) of the top level images, in the order they are
+// appended, and must be multiples of the tile size. Images without an offset are placed at 0,0.
+// The georeferencing tags and RPCs of each image are kept as is, and are not checked.
+func PixelSpace(offsets ...[2]uint64) Option {
+	return func(cog *MultiCOG) {
+		cog.pixelSpace = true
+		cog.pixelOffsets = offsets
+	}
+}
+
+// pixelTransform returns the transform from the pixels of the top level ifd i to the pixels of
+// the mucog in pixel space
+func (cog *MultiCOG) pixelTransform(i int) geotransform {
+	gt := geotransform{0, 1, 0, 0, 0, 1}
+	if i < len(cog.pixelOffsets) {
+		gt[0], gt[3] = float64(cog.pixelOffsets[i][0]), float64(cog.pixelOffsets[i][1])
+	}
+	return gt
+}
+
+const (
+	rpcLineOff   = 2
+	rpcSampOff   = 3
+	rpcLineScale = 7
+	rpcSampScale = 8
+)
+
+// scaleRPCs returns the RPCs of an image applied to its overview whose pixels are rx*ry times
+// larger, or nil if there are no RPCs
+func scaleRPCs(rpcs []float64, rx, ry float64) []float64 {
+	if len(rpcs) <= rpcSampScale {
+		return nil
+	}
+	s := append([]float64{}, rpcs...)
+	s[rpcLineOff] /= ry
+	s[rpcSampOff] /= rx
+	s[rpcLineScale] /= ry
+	s[rpcSampScale] /= rx
+	return s
+}
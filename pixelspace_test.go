@@ -0,0 +1,100 @@
+package mucog
+
+import (
+	"reflect"
+	"testing"
+)
+
+func testRawIFD(rpcs []float64) *IFD {
+	ifd := testLayoutIFD(0, 2, 10)
+	ifd.ModelPixelScaleTag, ifd.ModelTiePointTag = nil, nil
+	ifd.RPCs = rpcs
+	return ifd
+}
+
+func TestPixelSpace(t *testing.T) {
+	rpcs := make([]float64, 92)
+	for i := range rpcs {
+		rpcs[i] = float64(i)
+	}
+	newCOG := func(opts ...Option) *MultiCOG {
+		cog := New(opts...)
+		cog.AppendIFD(testRawIFD(rpcs))
+		cog.AppendIFD(testRawIFD(nil))
+		cog.AppendIFD(testRawIFD(rpcs))
+		return cog
+	}
+
+	report, err := newCOG().Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Issues) != 3 || report.Issues[0].Check != "geotransform" {
+		t.Errorf("got issues %v", report.Issues)
+	}
+
+	// all the images at 0,0
+	cog := newCOG(PixelSpace())
+	if err := cog.computeStructure(false); err != nil {
+		t.Fatal(err)
+	}
+	for i, ifd := range cog.ifds {
+		if ifd.minx != 0 || ifd.miny != 0 {
+			t.Errorf("ifd %d: got offset %d/%d", i, ifd.minx, ifd.miny)
+		}
+	}
+
+	cog = newCOG(PixelSpace([2]uint64{32, 16}, [2]uint64{0, 32}))
+	if err := cog.computeStructure(false); err != nil {
+		t.Fatal(err)
+	}
+	expected := [][2]uint64{{2, 1}, {0, 2}, {0, 0}}
+	for i, ifd := range cog.ifds {
+		if ifd.minx != expected[i][0] || ifd.miny != expected[i][1] {
+			t.Errorf("ifd %d: got offset %d/%d", i, ifd.minx, ifd.miny)
+		}
+	}
+	if !reflect.DeepEqual(cog.ifds[0].RPCs, rpcs) || cog.ifds[1].RPCs != nil || cog.ifds[0].ModelTransformationTag != nil {
+		t.Error("georeferencing was modified")
+	}
+
+	cog = newCOG(PixelSpace([2]uint64{0, 0}, [2]uint64{5, 0}))
+	if report, _ = cog.Check(); len(report.Issues) != 1 || report.Issues[0].Check != "alignment" || report.Issues[0].IFD != 1 {
+		t.Errorf("got issues %v", report.Issues)
+	}
+}
+
+func TestPixelSpaceShards(t *testing.T) {
+	cog := testShardedCOG()
+	PixelSpace([2]uint64{0, 0}, [2]uint64{32, 0}, [2]uint64{64, 0})(cog)
+	for _, ifd := range cog.ifds {
+		ifd.ModelPixelScaleTag, ifd.ModelTiePointTag = nil, nil
+		ifd.RPCs = make([]float64, 92)
+		for i := range ifd.RPCs {
+			ifd.RPCs[i] = 4
+		}
+	}
+	shards, err := cog.Shards("L=0;L=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ovr := shards[1]
+	if !reflect.DeepEqual(ovr.pixelOffsets, [][2]uint64{{0, 0}, {16, 0}, {32, 0}}) {
+		t.Errorf("got offsets %v", ovr.pixelOffsets)
+	}
+	top := ovr.ifds[0]
+	if top.ModelPixelScaleTag != nil || top.ModelTransformationTag != nil {
+		t.Error("promoted overview was georeferenced")
+	}
+	if top.RPCs[rpcLineOff] != 2 || top.RPCs[rpcSampScale] != 2 || top.RPCs[rpcLineOff-1] != 4 {
+		t.Errorf("got rpcs %v", top.RPCs[:rpcSampScale+1])
+	}
+	if err := ovr.computeStructure(false); err != nil {
+		t.Fatal(err)
+	}
+	for i, ifd := range ovr.ifds {
+		if ifd.minx != uint64(i) {
+			t.Errorf("ifd %d: got offset %d", i, ifd.minx)
+		}
+	}
+}
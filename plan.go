@@ -0,0 +1,123 @@
+package mucog
+
+import (
+	"sort"
+
+	"github.com/google/tiff"
+)
+
+// tilePlan is the list of the non-empty tiles of a mucog, in the order they are written.
+// It is stored as a struct of arrays to remain compact for millions of tiles.
+type tilePlan struct {
+	ifds   []*IFD   // ifds referenced by the plan
+	ifd    []uint32 // index in ifds of the ifd of each tile
+	tile   []uint32 // index of each tile in the strile arrays of its ifd
+	offset []uint64 // offset of each tile in the mucog
+	size   []uint32 // size of each tile
+	align  []bool   // tiles starting a group to align, nil if all the tiles are aligned
+
+	dataOffset uint64 // offset of the end of the header, where the tile data starts
+	frame      uint64 // size of the block leader and of the block trailer of each tile
+}
+
+// blockStart returns the offset of the i-th tile, including its leader
+func (p *tilePlan) blockStart(i int) uint64 {
+	return p.offset[i] - p.frame
+}
+
+// blockEnd returns the end offset of the i-th tile, including its trailer
+func (p *tilePlan) blockEnd(i int) uint64 {
+	return p.offset[i] + uint64(p.size[i]) + p.frame
+}
+
+// aligned returns true if the i-th tile must start on an alignment boundary
+func (p *tilePlan) aligned(i int) bool {
+	return p.align == nil || p.align[i]
+}
+
+// Len returns the number of tiles of the plan
+func (p *tilePlan) Len() int {
+	return len(p.tile)
+}
+
+// IFD returns the ifd of the i-th tile, and the index of the tile in its strile arrays
+func (p *tilePlan) IFD(i int) (*IFD, uint32) {
+	return p.ifds[p.ifd[i]], p.tile[i]
+}
+
+// computePlan traverses the interlacing pattern once to build the tile plan. Offsets are left
+// to be set by computeImageryOffsets.
+func (cog *MultiCOG) computePlan() {
+	plan := &tilePlan{}
+	ifdIdx := map[*IFD]uint32{}
+	grouped := cog.alignment > 1 && cog.alignDepth > 0 && cog.alignDepth < 4
+	if grouped {
+		plan.align = []bool{}
+	}
+	lastGroup := 0
+	cog.dataInterlacing().walk(cog.iterators, cog.alignDepth, func(ifd *IFD, x, y, plane uint64, group int) {
+		tileidx := (x+y*ifd.ntilesx)*ifd.nplanes + plane
+		if ifd.TileByteCounts[tileidx] == 0 {
+			return
+		}
+		if grouped {
+			// the first non-empty tile of the group is aligned
+			plan.align = append(plan.align, group != lastGroup)
+			lastGroup = group
+		}
+		idx, ok := ifdIdx[ifd]
+		if !ok {
+			idx = uint32(len(plan.ifds))
+			ifdIdx[ifd] = idx
+			plan.ifds = append(plan.ifds, ifd)
+		}
+		plan.ifd = append(plan.ifd, idx)
+		plan.tile = append(plan.tile, uint32(tileidx))
+		plan.size = append(plan.size, ifd.TileByteCounts[tileidx])
+	})
+	plan.offset = make([]uint64, len(plan.tile))
+	if cog.sourceOrder {
+		plan.sortBySource()
+	}
+	cog.plan = plan
+}
+
+// sortBySource reorders the tiles of the plan by source reader, then by offset in the source
+func (p *tilePlan) sortBySource() {
+	rids := map[tiff.BReader]int{}
+	for _, ifd := range p.ifds {
+		if _, ok := rids[ifd.r]; !ok {
+			rids[ifd.r] = len(rids)
+		}
+	}
+	order := make([]int, p.Len())
+	for i := range order {
+		order[i] = i
+	}
+	key := func(i int) (int, uint64) {
+		ifd, idx := p.IFD(i)
+		return rids[ifd.r], ifd.OriginalTileOffsets[idx]
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		ri, oi := key(order[i])
+		rj, oj := key(order[j])
+		return ri < rj || (ri == rj && oi < oj)
+	})
+	sorted := &tilePlan{
+		ifds:   p.ifds,
+		ifd:    make([]uint32, len(order)),
+		tile:   make([]uint32, len(order)),
+		offset: p.offset,
+		size:   make([]uint32, len(order)),
+	}
+	if p.align != nil {
+		sorted.align = make([]bool, len(order))
+	}
+	for k, i := range order {
+		sorted.ifd[k], sorted.tile[k], sorted.size[k] = p.ifd[i], p.tile[i], p.size[i]
+		if p.align != nil {
+			sorted.align[k] = p.align[i]
+		}
+	}
+	*p = *sorted
+}
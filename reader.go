@@ -0,0 +1,26 @@
+package mucog
+
+import "io"
+
+// Reader returns a reader producing the bytes of the mucog, as Write would write them, along
+// with the total size of the mucog. The file is produced lazily as the reader is consumed, so
+// it can be streamed to e.g. an HTTP upload with a known Content-Length without being written to
+// disk first.
+//
+// The MultiCOG must not be modified nor written until the returned reader has been fully consumed
+// or closed.
+func (cog *MultiCOG) Reader(bigtiff bool, pattern string) (io.ReadCloser, int64, error) {
+	if err := cog.prepare(bigtiff, pattern); err != nil {
+		return nil, 0, err
+	}
+	size := int64(cog.size(bigtiff))
+	pr, pw := io.Pipe()
+	go func() {
+		err := cog.writeHeaders(pw, bigtiff)
+		if err == nil {
+			err = cog.copyTiles(pw)
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr, size, nil
+}
@@ -0,0 +1,51 @@
+package mucog
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestReader(t *testing.T) {
+	for _, bigtiff := range []bool{false, true} {
+		expected := &bytes.Buffer{}
+		if err := testInterlacedCOG().Write(expected, bigtiff, MUCOGPattern); err != nil {
+			t.Fatal(err)
+		}
+
+		r, size, err := testInterlacedCOG(CopyBufferSize(16)).Reader(bigtiff, MUCOGPattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if size != int64(expected.Len()) {
+			t.Errorf("size %d, expected %d", size, expected.Len())
+		}
+		if !bytes.Equal(got, expected.Bytes()) {
+			t.Error("reader content differs from Write")
+		}
+	}
+}
+
+func TestReaderClose(t *testing.T) {
+	r, _, err := testInterlacedCOG(CopyBufferSize(8)).Reader(false, MUCOGPattern)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 10)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Read(buf); err == nil {
+		t.Error("expected error reading a closed reader")
+	}
+}
@@ -0,0 +1,153 @@
+package mucog
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// checkpoint records the progress of a resumable write
+type checkpoint struct {
+	Layout string `json:"layout"` // hash of the planned layout
+	Tiles  int    `json:"tiles"`  // number of tiles of the plan durably written
+}
+
+// WriteResumable writes the mucog to out like Write, recording its progress in the checkpoint
+// sidecar file. If the checkpoint exists, the write is resumed after the last tile that was
+// completely written: the inputs and pattern must be the same as for the interrupted write, which
+// is verified against the layout hash of the checkpoint and the header already present in out.
+// If the checkpoint does not exist, out is truncated and written from scratch.
+//
+// The checkpoint is removed once the write completes.
+func (cog *MultiCOG) WriteResumable(out *os.File, checkpointFile string, bigtiff bool, pattern string) error {
+	if err := cog.prepare(bigtiff, pattern); err != nil {
+		return err
+	}
+	header := &bytes.Buffer{}
+	if err := cog.writeHeaders(header, bigtiff); err != nil {
+		return err
+	}
+	layout := cog.layoutHash(header.Bytes())
+
+	start := 0
+	cp, err := readCheckpoint(checkpointFile)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		if err := out.Truncate(0); err != nil {
+			return fmt.Errorf("truncate output: %w", err)
+		}
+		if _, err := out.WriteAt(header.Bytes(), 0); err != nil {
+			return fmt.Errorf("write headers: %w", err)
+		}
+		if err := out.Sync(); err != nil {
+			return fmt.Errorf("sync output: %w", err)
+		}
+		if err := writeCheckpoint(checkpointFile, checkpoint{Layout: layout}); err != nil {
+			return err
+		}
+	case err != nil:
+		return err
+	default:
+		if cp.Layout != layout {
+			return fmt.Errorf("checkpoint %s does not match the layout of the mucog", checkpointFile)
+		}
+		if err := checkHeader(out, header.Bytes()); err != nil {
+			return err
+		}
+		st, err := out.Stat()
+		if err != nil {
+			return fmt.Errorf("stat output: %w", err)
+		}
+		// do not trust tiles that are not entirely present in the output
+		start = cp.Tiles
+		if start > cog.plan.Len() {
+			start = cog.plan.Len()
+		}
+		for start > 0 && cog.plan.blockEnd(start-1) > uint64(st.Size()) {
+			start--
+		}
+	}
+
+	done := func(end int) error {
+		if err := out.Sync(); err != nil {
+			return fmt.Errorf("sync output: %w", err)
+		}
+		return writeCheckpoint(checkpointFile, checkpoint{Layout: layout, Tiles: end})
+	}
+	if err := cog.copyTilesAt(out, int64(cog.plan.dataOffset), start, done); err != nil {
+		return err
+	}
+	// drop any trailing data of a previous, different, output
+	if err := out.Truncate(int64(cog.size(bigtiff))); err != nil {
+		return fmt.Errorf("truncate output: %w", err)
+	}
+	if err := out.Sync(); err != nil {
+		return fmt.Errorf("sync output: %w", err)
+	}
+	if err := os.Remove(checkpointFile); err != nil {
+		return fmt.Errorf("remove checkpoint: %w", err)
+	}
+	return nil
+}
+
+// layoutHash returns a hash identifying the header of the mucog and the source of each of its tiles
+func (cog *MultiCOG) layoutHash(header []byte) string {
+	h := sha256.New()
+	h.Write(header)
+	var buf [8]byte
+	for i := 0; i < cog.plan.Len(); i++ {
+		ifd, idx := cog.plan.IFD(i)
+		binary.LittleEndian.PutUint64(buf[:], ifd.OriginalTileOffsets[idx])
+		h.Write(buf[:])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// checkHeader verifies that out starts with header
+func checkHeader(out *os.File, header []byte) error {
+	buf := make([]byte, len(header))
+	if n, err := out.ReadAt(buf, 0); n < len(buf) {
+		if err == nil || err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return fmt.Errorf("read output header: %w", err)
+	}
+	if !bytes.Equal(buf, header) {
+		return fmt.Errorf("output header does not match the layout of the mucog")
+	}
+	return nil
+}
+
+func readCheckpoint(name string) (checkpoint, error) {
+	cp := checkpoint{}
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return cp, fmt.Errorf("read checkpoint: %w", err)
+	}
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return cp, fmt.Errorf("decode checkpoint %s: %w", name, err)
+	}
+	return cp, nil
+}
+
+// writeCheckpoint atomically replaces the checkpoint file
+func writeCheckpoint(name string, cp checkpoint) error {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return fmt.Errorf("encode checkpoint: %w", err)
+	}
+	tmp := name + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("write checkpoint: %w", err)
+	}
+	if err := os.Rename(tmp, name); err != nil {
+		return fmt.Errorf("write checkpoint: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package mucog
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// failingSource fails reads beyond limit, to interrupt a write
+type failingSource struct {
+	*bytes.Reader
+	limit int64
+}
+
+func (f failingSource) ReadAt(p []byte, off int64) (int, error) {
+	if off+int64(len(p)) > f.limit {
+		return 0, errors.New("source unavailable")
+	}
+	return f.Reader.ReadAt(p, off)
+}
+
+func TestWriteResumable(t *testing.T) {
+	dir := t.TempDir()
+	outName := filepath.Join(dir, "out.tif")
+	cpName := outName + ".checkpoint"
+	pattern := "L>T>I>P"
+
+	expected := &bytes.Buffer{}
+	if err := testInterlacedCOG().Write(expected, false, pattern); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := os.Create(outName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+
+	src := failingSource{bytes.NewReader(testInterlacedData()), 40}
+	err = testInterlacedCOGFrom(src, CopyBufferSize(16)).WriteResumable(out, cpName, false, pattern)
+	if err == nil {
+		t.Fatal("expected interrupted write")
+	}
+	cp, err := readCheckpoint(cpName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cp.Tiles == 0 || cp.Tiles >= 8 {
+		t.Errorf("unexpected progress %d", cp.Tiles)
+	}
+
+	// the layout must match the interrupted write
+	if err := testInterlacedCOG().WriteResumable(out, cpName, false, "I>L>T>P"); err == nil {
+		t.Error("expected layout mismatch error")
+	}
+
+	// tiles recorded in the checkpoint but missing from the output are written again
+	st, _ := out.Stat()
+	if err := out.Truncate(st.Size() - 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := testInterlacedCOG().WriteResumable(out, cpName, false, pattern); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(outName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, expected.Bytes()) {
+		t.Error("resumed output differs from Write")
+	}
+	if _, err := os.Stat(cpName); !os.IsNotExist(err) {
+		t.Errorf("checkpoint not removed: %v", err)
+	}
+
+	// without checkpoint, the output is rewritten from scratch
+	if err := testInterlacedCOG().WriteResumable(out, cpName, true, MUCOGPattern); err != nil {
+		t.Fatal(err)
+	}
+	expected.Reset()
+	if err := testInterlacedCOG().Write(expected, true, MUCOGPattern); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(outName); !bytes.Equal(got, expected.Bytes()) {
+		t.Error("rewritten output differs from Write")
+	}
+}
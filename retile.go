@@ -0,0 +1,257 @@
+package mucog
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/google/tiff"
+)
+
+// RetileStrips converts stripped inputs into tiles of width x length pixels (multiples of 16),
+// instead of rejecting them. The strips are decoded, cut into tiles and re-encoded with the
+// same compression and predictor, which must be one of none, LZW, Deflate or PackBits, and no
+// predictor or the horizontal one. The tiles are encoded once when loading to compute their
+// sizes, and again on the fly when they are copied, so that the image is not held in memory.
+func RetileStrips(width, length uint16) LoadOption {
+	return func(o *loadOptions) {
+		o.retileWidth, o.retileLength = width, length
+	}
+}
+
+// stripLayout holds the strip tags of an ifd
+type stripLayout struct {
+	StripOffsets    []uint64 `tiff:"field,tag=273"`
+	RowsPerStrip    uint64   `tiff:"field,tag=278"`
+	StripByteCounts []uint64 `tiff:"field,tag=279"`
+}
+
+// retile replaces the strips of ifd by tiles of width x length pixels
+func (ifd *IFD) retile(tifd tiff.IFD, width, length uint16) error {
+	if width == 0 || length == 0 || width%16 != 0 || length%16 != 0 {
+		return fmt.Errorf("invalid tile size %dx%d: must be multiples of 16", width, length)
+	}
+	strips := stripLayout{}
+	if err := tiff.UnmarshalIFD(tifd, &strips); err != nil {
+		return fmt.Errorf("strips: %w", err)
+	}
+	c, ok := codecs[ifd.Compression]
+	if !ok {
+		return fmt.Errorf("cannot retile strips compressed with %d", ifd.Compression)
+	}
+	if ifd.Predictor > PredictorHorizontal {
+		return fmt.Errorf("cannot retile strips with predictor %d", ifd.Predictor)
+	}
+	if ifd.PhotometricInterpretation == PhotometricInterpretationYCbCr && ifd.Compression == 1 &&
+		(len(ifd.YCbCrSubsampling) < 2 || ifd.YCbCrSubsampling[0] != 1 || ifd.YCbCrSubsampling[1] != 1) {
+		return fmt.Errorf("cannot retile subsampled YCbCr strips")
+	}
+	if len(ifd.BitsPerSample) == 0 {
+		return fmt.Errorf("missing bits per sample")
+	}
+	rt := &retiler{
+		r: ifd.r, c: c, strips: strips, predictor: ifd.Predictor,
+		height: int(ifd.ImageLength), width: int(width), length: int(length),
+		planes: int(ifd.planeCount()), strip: -1, bandPlane: -1, tile: -1,
+	}
+	rt.sampleSize = int(ifd.BitsPerSample[0]) / 8
+	for _, b := range ifd.BitsPerSample {
+		if b%8 != 0 || int(b)/8 != rt.sampleSize {
+			return fmt.Errorf("cannot retile strips of %v bits per sample", ifd.BitsPerSample)
+		}
+		rt.pixelSize += rt.sampleSize
+	}
+	rt.stride = len(ifd.BitsPerSample)
+	if rt.planes > 1 {
+		rt.pixelSize, rt.stride = rt.sampleSize, 1
+	}
+	rt.rowsPerStrip = int(strips.RowsPerStrip)
+	if strips.RowsPerStrip == 0 || strips.RowsPerStrip > ifd.ImageLength {
+		rt.rowsPerStrip = rt.height
+	}
+	rt.stripsPerPlane = (rt.height + rt.rowsPerStrip - 1) / rt.rowsPerStrip
+	if len(strips.StripOffsets) != rt.stripsPerPlane*rt.planes || len(strips.StripByteCounts) != len(strips.StripOffsets) {
+		return fmt.Errorf("got %d/%d strips, expected %d", len(strips.StripOffsets), len(strips.StripByteCounts), rt.stripsPerPlane*rt.planes)
+	}
+	rt.rowSize = int(ifd.ImageWidth) * rt.pixelSize
+	rt.ntx = (int(ifd.ImageWidth) + rt.width - 1) / rt.width
+	rt.nty = (rt.height + rt.length - 1) / rt.length
+
+	// the tiles are encoded a first time to compute their sizes, and thus the tile offsets
+	ntiles := rt.planes * rt.nty * rt.ntx
+	offsets := make([]uint64, ntiles)
+	counts := make([]uint32, ntiles)
+	size := uint64(0)
+	for idx := 0; idx < ntiles; idx++ {
+		data, err := rt.encodeTile(idx)
+		if err != nil {
+			return err
+		}
+		if uint64(len(data)) > math.MaxUint32 {
+			return fmt.Errorf("tile %d is too large", idx)
+		}
+		offsets[idx], counts[idx] = size, uint32(len(data))
+		size += uint64(len(data))
+	}
+	rt.offsets, rt.counts = offsets, counts
+
+	ifd.TileWidth, ifd.TileLength = width, length
+	ifd.OriginalTileOffsets, ifd.TileByteCounts = offsets, counts
+	ifd.r = tiff.NewBReader(io.NewSectionReader(rt, 0, int64(size)), ifd.r.ByteOrder())
+	ifd.retiled = true
+	// RowsPerStrip is not handled by IFD
+	tags := ifd.ExtraTags[:0]
+	for _, tag := range ifd.ExtraTags {
+		if tag.ID != 278 {
+			tags = append(tags, tag)
+		}
+	}
+	ifd.ExtraTags = tags
+	return nil
+}
+
+// retiler is a reader of the tiles of a stripped image, which are encoded from its strips on
+// demand. The tiles are laid out contiguously, at the offsets of the tile plan of the image.
+type retiler struct {
+	r         tiff.BReader
+	c         codec
+	strips    stripLayout
+	predictor uint16
+
+	height, width, length        int //image height, tile width and length in pixels
+	sampleSize, pixelSize        int //in bytes
+	stride, planes               int //samples between two values of a sample, number of planes
+	rowsPerStrip, stripsPerPlane int
+	rowSize                      int //size of a decoded row of the image
+	ntx, nty                     int
+
+	offsets []uint64 //offsets of the encoded tiles
+	counts  []uint32
+
+	mu                 sync.Mutex
+	strip              int    //index of the last decoded strip
+	stripData          []byte //decoded rows of the strip
+	bandPlane, bandRow int    //plane and tile row of the decoded rows of band
+	band               []byte
+	tile               int //index of the last encoded tile
+	tileData           []byte
+}
+
+// decodeStrip returns the rows of strip s of plane p, with the predictor reverted
+func (rt *retiler) decodeStrip(p, s int) ([]byte, error) {
+	idx := p*rt.stripsPerPlane + s
+	if idx == rt.strip {
+		return rt.stripData, nil
+	}
+	buf := make([]byte, rt.strips.StripByteCounts[idx])
+	if _, err := rt.r.ReadAt(buf, int64(rt.strips.StripOffsets[idx])); err != nil {
+		return nil, fmt.Errorf("read strip %d: %w", idx, err)
+	}
+	strip, err := rt.c.decode(buf)
+	if err != nil {
+		return nil, fmt.Errorf("decode strip %d: %w", idx, err)
+	}
+	srows := rt.height - s*rt.rowsPerStrip
+	if srows > rt.rowsPerStrip {
+		srows = rt.rowsPerStrip
+	}
+	if len(strip) < srows*rt.rowSize {
+		return nil, fmt.Errorf("strip %d is too short: %d/%d bytes", idx, len(strip), srows*rt.rowSize)
+	}
+	strip = strip[:srows*rt.rowSize]
+	if rt.predictor == PredictorHorizontal {
+		if err := horizontalPredictor(strip, rt.rowSize, rt.sampleSize, rt.stride, rt.r.ByteOrder(), true); err != nil {
+			return nil, err
+		}
+	}
+	rt.strip, rt.stripData = idx, strip
+	return strip, nil
+}
+
+// decodeBand returns the decoded rows of tile row ty of plane p
+func (rt *retiler) decodeBand(p, ty int) ([]byte, error) {
+	if p == rt.bandPlane && ty == rt.bandRow {
+		return rt.band, nil
+	}
+	first := ty * rt.length
+	last := first + rt.length
+	if last > rt.height {
+		last = rt.height
+	}
+	band := make([]byte, 0, (last-first)*rt.rowSize)
+	for s := first / rt.rowsPerStrip; s*rt.rowsPerStrip < last; s++ {
+		strip, err := rt.decodeStrip(p, s)
+		if err != nil {
+			return nil, err
+		}
+		start, end := first-s*rt.rowsPerStrip, last-s*rt.rowsPerStrip
+		if start < 0 {
+			start = 0
+		}
+		if end > rt.rowsPerStrip {
+			end = rt.rowsPerStrip
+		}
+		band = append(band, strip[start*rt.rowSize:end*rt.rowSize]...)
+	}
+	rt.bandPlane, rt.bandRow, rt.band = p, ty, band
+	return band, nil
+}
+
+// encodeTile returns the encoded tile idx, edge tiles being padded with zeros
+func (rt *retiler) encodeTile(idx int) ([]byte, error) {
+	if idx == rt.tile {
+		return rt.tileData, nil
+	}
+	p, ty, tx := idx/(rt.nty*rt.ntx), idx/rt.ntx%rt.nty, idx%rt.ntx
+	rows, err := rt.decodeBand(p, ty)
+	if err != nil {
+		return nil, err
+	}
+	tileRowSize := rt.width * rt.pixelSize
+	tile := make([]byte, rt.length*tileRowSize)
+	for r := 0; r < len(rows)/rt.rowSize; r++ {
+		row := rows[r*rt.rowSize : (r+1)*rt.rowSize]
+		end := (tx + 1) * tileRowSize
+		if end > rt.rowSize {
+			end = rt.rowSize
+		}
+		copy(tile[r*tileRowSize:], row[tx*tileRowSize:end])
+	}
+	if rt.predictor == PredictorHorizontal {
+		if err := horizontalPredictor(tile, tileRowSize, rt.sampleSize, rt.stride, rt.r.ByteOrder(), false); err != nil {
+			return nil, err
+		}
+	}
+	data, err := rt.c.encode(tile)
+	if err != nil {
+		return nil, fmt.Errorf("encode tile %d: %w", idx, err)
+	}
+	if rt.counts != nil && len(data) != int(rt.counts[idx]) {
+		return nil, fmt.Errorf("tile %d was encoded to %d bytes instead of %d", idx, len(data), rt.counts[idx])
+	}
+	rt.tile, rt.tileData = idx, data
+	return data, nil
+}
+
+// ReadAt reads the encoded tiles overlapping [off,off+len(p))
+func (rt *retiler) ReadAt(p []byte, off int64) (int, error) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	n := 0
+	for n < len(p) {
+		pos := uint64(off) + uint64(n)
+		// last tile starting at or before pos
+		idx := sort.Search(len(rt.offsets), func(i int) bool { return rt.offsets[i] > pos }) - 1
+		if idx < 0 || pos >= rt.offsets[idx]+uint64(rt.counts[idx]) {
+			return n, io.EOF
+		}
+		data, err := rt.encodeTile(idx)
+		if err != nil {
+			return n, err
+		}
+		n += copy(p[n:], data[pos-rt.offsets[idx]:])
+	}
+	return n, nil
+}
@@ -0,0 +1,298 @@
+package mucog
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/google/tiff"
+	xlzw "golang.org/x/image/tiff/lzw"
+)
+
+// testStrippedTIFF returns a little endian stripped tiff of 8 bits contiguous samples
+func testStrippedTIFF(t *testing.T, width, height, rowsPerStrip, spp int, compression, predictor uint16, pixels []byte) []byte {
+	enc := binary.LittleEndian
+	rowSize := width * spp
+	data := &bytes.Buffer{}
+	var offsets, counts []uint32
+	for row := 0; row < height; row += rowsPerStrip {
+		end := row + rowsPerStrip
+		if end > height {
+			end = height
+		}
+		strip := append([]byte{}, pixels[row*rowSize:end*rowSize]...)
+		if predictor == PredictorHorizontal {
+			if err := horizontalPredictor(strip, rowSize, 1, spp, enc, false); err != nil {
+				t.Fatal(err)
+			}
+		}
+		encoded, err := codecs[compression].encode(strip)
+		if err != nil {
+			t.Fatal(err)
+		}
+		offsets = append(offsets, uint32(8+data.Len()))
+		counts = append(counts, uint32(len(encoded)))
+		data.Write(encoded)
+	}
+	bits := make([]uint32, spp)
+	for i := range bits {
+		bits[i] = 8
+	}
+	return testTIFFFile(data.Bytes(), []testEntry{
+		{256, TLong, []uint32{uint32(width)}},
+		{257, TLong, []uint32{uint32(height)}},
+		{258, TShort, bits},
+		{259, TShort, []uint32{uint32(compression)}},
+		{262, TShort, []uint32{PhotometricInterpretationMinIsBlack}},
+		{273, TLong, offsets},
+		{277, TShort, []uint32{uint32(spp)}},
+		{278, TLong, []uint32{uint32(rowsPerStrip)}},
+		{279, TLong, counts},
+		{284, TShort, []uint32{PlanarConfigurationContig}},
+		{317, TShort, []uint32{uint32(predictor)}},
+	})
+}
+
+// testEntry is a SHORT or LONG tiff field
+type testEntry struct {
+	tag, typ uint16
+	values   []uint32
+}
+
+// testTIFFFile returns a little endian classic tiff holding data right after the header,
+// followed by the chain of ifds
+func testTIFFFile(data []byte, ifds ...[]testEntry) []byte {
+	enc := binary.LittleEndian
+	buf := &bytes.Buffer{}
+	buf.Write([]byte("II"))
+	binary.Write(buf, enc, uint16(42))
+	binary.Write(buf, enc, uint32(8+len(data)+len(data)%2))
+	buf.Write(data)
+	if len(data)%2 == 1 {
+		buf.WriteByte(0)
+	}
+	for i, entries := range ifds {
+		ifdOffset := uint32(buf.Len())
+		overflow := &bytes.Buffer{}
+		overflowOffset := ifdOffset + 2 + uint32(12*len(entries)) + 4
+		binary.Write(buf, enc, uint16(len(entries)))
+		for _, e := range entries {
+			value := &bytes.Buffer{}
+			for _, v := range e.values {
+				if e.typ == TShort {
+					binary.Write(value, enc, uint16(v))
+				} else {
+					binary.Write(value, enc, v)
+				}
+			}
+			binary.Write(buf, enc, e.tag)
+			binary.Write(buf, enc, e.typ)
+			binary.Write(buf, enc, uint32(len(e.values)))
+			if value.Len() <= 4 {
+				buf.Write(append(value.Bytes(), make([]byte, 4-value.Len())...))
+			} else {
+				binary.Write(buf, enc, overflowOffset+uint32(overflow.Len()))
+				overflow.Write(value.Bytes())
+			}
+		}
+		next := uint32(0)
+		if i < len(ifds)-1 {
+			next = overflowOffset + uint32(overflow.Len()+overflow.Len()%2)
+		}
+		binary.Write(buf, enc, next)
+		buf.Write(overflow.Bytes())
+		if overflow.Len()%2 == 1 {
+			buf.WriteByte(0)
+		}
+	}
+	return buf.Bytes()
+}
+
+func TestRetileStrips(t *testing.T) {
+	width, height, spp := 40, 35, 3
+	pixels := make([]byte, width*height*spp)
+	rnd := rand.New(rand.NewSource(1))
+	for i := range pixels {
+		// compressible, but not trivially
+		pixels[i] = byte(i/7) + byte(rnd.Intn(4))
+	}
+	for _, compression := range []uint16{1, 5, 8, 32773, 32946} {
+		for _, predictor := range []uint16{PredictorNone, PredictorHorizontal} {
+			file := testStrippedTIFF(t, width, height, 7, spp, compression, predictor, pixels)
+			tif, err := tiff.Parse(bytes.NewReader(file), nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := LoadTIFF(tif); err == nil || !strings.Contains(err.Error(), "tif has strips, use RetileStrips") {
+				t.Errorf("compression %d: expected strips to be rejected, got %v", compression, err)
+			}
+			ifds, err := LoadTIFF(tif, RetileStrips(16, 32), LoadSource(bytes.NewReader(file)))
+			if err != nil {
+				t.Fatalf("compression %d predictor %d: %v", compression, predictor, err)
+			}
+			ifd := ifds[0]
+			if ifd.TileWidth != 16 || ifd.TileLength != 32 || len(ifd.TileByteCounts) != 6 {
+				t.Fatalf("compression %d: got %dx%d tiles, %d tiles", compression, ifd.TileWidth, ifd.TileLength, len(ifd.TileByteCounts))
+			}
+			if ifd.src != nil {
+				t.Error("retiled ifd must not be copied from its source")
+			}
+			for _, tag := range ifd.ExtraTags {
+				if tag.ID == 278 {
+					t.Error("RowsPerStrip was kept")
+				}
+			}
+			for idx := range ifd.TileByteCounts {
+				tile, err := codecs[compression].decode(readTile(t, ifd, idx))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if predictor == PredictorHorizontal {
+					horizontalPredictor(tile, 16*spp, 1, spp, binary.LittleEndian, true)
+				}
+				tx, ty := idx%3, idx/3
+				for y := 0; y < 32; y++ {
+					for x := 0; x < 16*spp; x++ {
+						px, py := tx*16*spp+x, ty*32+y
+						expected := byte(0)
+						if px < width*spp && py < height {
+							expected = pixels[py*width*spp+px]
+						}
+						if tile[y*16*spp+x] != expected {
+							t.Fatalf("compression %d predictor %d: tile %d pixel %d/%d: got %d, expected %d",
+								compression, predictor, idx, x, y, tile[y*16*spp+x], expected)
+						}
+					}
+				}
+				// tiles are encoded on demand, in any order and across tile boundaries
+				var tiles [][]byte
+				for idx := len(ifd.TileByteCounts) - 1; idx >= 0; idx-- {
+					tiles = append([][]byte{readTile(t, ifd, idx)}, tiles...)
+				}
+				all := make([]byte, ifd.OriginalTileOffsets[5]+uint64(ifd.TileByteCounts[5]))
+				if _, err := ifd.r.ReadAt(all, 0); err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(all, bytes.Join(tiles, nil)) {
+					t.Errorf("compression %d predictor %d: tiles differ", compression, predictor)
+				}
+			}
+		}
+	}
+
+	// the retiled image can be assembled in a mucog
+	file := testStrippedTIFF(t, width, height, 7, spp, 5, PredictorHorizontal, pixels)
+	tif, err := tiff.Parse(bytes.NewReader(file), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ifds, err := LoadTIFF(tif, RetileStrips(16, 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cog := New(PixelSpace())
+	cog.AppendIFD(ifds[0])
+	_, out := writeAndLoad(t, cog, false)
+	for idx := range out[0].TileByteCounts {
+		if !bytes.Equal(readTile(t, out[0], idx), readTile(t, ifds[0], idx)) {
+			t.Errorf("tile %d differs", idx)
+		}
+	}
+
+	if _, err := LoadTIFF(tif, RetileStrips(10, 16)); err == nil {
+		t.Error("expected invalid tile size")
+	}
+	file = testStrippedTIFF(t, width, height, 7, spp, 1, PredictorNone, pixels)
+	file[bytes.Index(file, []byte{3, 1, 3, 0, 1, 0, 0, 0, 1, 0})+8] = 7 //JPEG
+	if tif, err = tiff.Parse(bytes.NewReader(file), nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadTIFF(tif, RetileStrips(16, 16)); err == nil {
+		t.Error("expected unsupported compression")
+	}
+}
+
+func TestCodecs(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for _, n := range []int{0, 1, 2, 3, 127, 128, 129, 600, 5000, 100000} {
+		src := make([]byte, n)
+		for i := range src {
+			src[i] = byte(rnd.Intn(3))
+			if i > 200 && i < 500 {
+				src[i] = 1
+			}
+		}
+		for compression, c := range codecs {
+			encoded, err := c.encode(src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded, err := c.decode(encoded)
+			if err != nil {
+				t.Fatalf("compression %d, %d bytes: %v", compression, n, err)
+			}
+			if !bytes.Equal(decoded, src) {
+				t.Errorf("compression %d, %d bytes: round trip differs", compression, n)
+			}
+		}
+	}
+}
+
+// TestCodecsInterop checks the codecs against other implementations, which a round trip
+// through a symmetric bug would not catch
+func TestCodecsInterop(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for _, n := range []int{0, 1, 600, 5000, 100000} {
+		src := make([]byte, n)
+		for i := range src {
+			src[i] = byte(rnd.Intn(3))
+			if i > 200 && i < 500 {
+				src[i] = 1
+			}
+		}
+
+		// LZW with the early change of the code width of libtiff, and clear codes once the
+		// table is full
+		encoded, err := lzwEncode(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lr := xlzw.NewReader(bytes.NewReader(encoded), xlzw.MSB, 8)
+		decoded, err := io.ReadAll(lr)
+		lr.Close()
+		if err != nil || !bytes.Equal(decoded, src) {
+			t.Errorf("lzw %d bytes: x/image decoder differs: %v", n, err)
+		}
+
+		encoded, err = deflateEncode(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		zr, err := zlib.NewReader(bytes.NewReader(encoded))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decoded, err = io.ReadAll(zr); err != nil || !bytes.Equal(decoded, src) {
+			t.Errorf("deflate %d bytes: zlib decoder differs: %v", n, err)
+		}
+		buf := &bytes.Buffer{}
+		zw, _ := zlib.NewWriterLevel(buf, zlib.BestCompression)
+		zw.Write(src)
+		zw.Close()
+		if decoded, err = deflateDecode(buf.Bytes()); err != nil || !bytes.Equal(decoded, src) {
+			t.Errorf("deflate %d bytes: zlib encoder output differs: %v", n, err)
+		}
+	}
+
+	// PackBits example of the TIFF 6.0 specification
+	packed := []byte{0xfe, 0xaa, 0x02, 0x80, 0x00, 0x2a, 0xfd, 0xaa, 0x03, 0x80, 0x00, 0x2a, 0x22, 0xf7, 0xaa}
+	unpacked := []byte{0xaa, 0xaa, 0xaa, 0x80, 0x00, 0x2a, 0xaa, 0xaa, 0xaa, 0xaa, 0x80, 0x00, 0x2a, 0x22,
+		0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa}
+	if decoded, err := packBitsDecode(packed); err != nil || !bytes.Equal(decoded, unpacked) {
+		t.Errorf("packbits: got %x, %v", decoded, err)
+	}
+}
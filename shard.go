@@ -0,0 +1,254 @@
+package mucog
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Shard is a standalone mucog holding a subset of the images and levels of a larger mucog
+type Shard struct {
+	*MultiCOG
+	Images []int // images of the original mucog held by the shard
+	Levels []int // levels of the original mucog held by the shard
+}
+
+// ShardIndex describes which shard of a sharded mucog holds which image and level
+type ShardIndex struct {
+	Shards []ShardIndexEntry `json:"shards"`
+}
+
+// ShardIndexEntry is the entry of a shard in a ShardIndex
+type ShardIndexEntry struct {
+	File   string `json:"file"`
+	Images []int  `json:"images"`
+	Levels []int  `json:"levels"`
+}
+
+/** Shards splits the mucog into several standalone mucogs, along image and level boundaries.
+ *
+ * The spec is a ";" separated list of shards, each of them selecting images and levels with the
+ * syntax of the interlacing patterns: I=0:10>L=0 selects the full resolution of the first ten
+ * images. Values (I=0,2,3) or ranges (L=1:) can be used, and a key that is omitted selects all the
+ * images or levels. For example:
+ * - One shard for the full resolution, one for the overviews: L=0;L=1:
+ * - One shard per year of monthly images: I=0:12;I=12:24;I=24:
+ *
+ * The lowest selected level of an image is the top level IFD of the image in the shard, and is
+ * georeferenced from the full resolution image when it is an overview. Levels are thus renumbered
+ * from 0 in each shard, which must be accounted for in the pattern used to write the shards.
+ * A level of an image cannot be selected by several shards.
+ */
+func (cog *MultiCOG) Shards(spec string) ([]*Shard, error) {
+	if len(cog.ifds) == 0 {
+		return nil, fmt.Errorf("empty ifds")
+	}
+	// zoom levels are assigned when computing the structure
+	if err := cog.computeStructure(true); err != nil {
+		return nil, err
+	}
+	data := cog.dataInterlacing()
+	nLevels := 0
+	for _, levels := range data {
+		if len(levels) > nLevels {
+			nLevels = len(levels)
+		}
+	}
+	factors := levelFactors(data, nLevels)
+
+	used := map[[2]int]int{}
+	var shards []*Shard
+	for s, sel := range strings.Split(spec, ";") {
+		images, levels, err := parseShard(sel, len(cog.ifds), nLevels)
+		if err != nil {
+			return nil, fmt.Errorf("shard %d: %w", s, err)
+		}
+		shard := &Shard{MultiCOG: cog.clone(), Levels: levels}
+		for _, i := range images {
+			var selected []int
+			for _, l := range levels {
+				if l < len(data[i]) {
+					if prev, ok := used[[2]int{i, l}]; ok {
+						return nil, fmt.Errorf("shard %d: image %d level %d already in shard %d", s, i, l, prev)
+					}
+					used[[2]int{i, l}] = s
+					selected = append(selected, l)
+				}
+			}
+			if len(selected) == 0 {
+				continue
+			}
+			shard.AppendIFD(shardIFD(cog.ifds[i], data[i], selected, factors[selected[0]]))
+			shard.Images = append(shard.Images, i)
+			if cog.pixelSpace {
+				first := data[i][selected[0]][0]
+				shard.pixelOffsets = append(shard.pixelOffsets,
+					[2]uint64{first.minx * uint64(first.TileWidth), first.miny * uint64(first.TileLength)})
+			}
+		}
+		if len(shard.Images) == 0 {
+			return nil, fmt.Errorf("shard %d: %s selects no image", s, sel)
+		}
+		shards = append(shards, shard)
+	}
+	return shards, nil
+}
+
+// clone returns an empty MultiCOG with the same options as cog
+func (cog *MultiCOG) clone() *MultiCOG {
+	c := *cog
+	c.ifds, c.iterators, c.plan, c.ghostArea, c.pixelOffsets = nil, nil, nil, nil, nil
+	// the issues of the images are reported when the shards are computed
+	c.onCheck = nil
+	return &c
+}
+
+// levelFactors returns the zoom factor of each level, shared by all the images so that their
+// promoted overviews have the same scale whatever the rounding of their sizes. It is the factor
+// of the largest image, rounded to the integer factor its overview size was computed from
+// (ceil(size/factor), as done by GDAL) when there is one.
+func levelFactors(data datas, nLevels int) []float64 {
+	factors := make([]float64, nLevels)
+	largest := make([]uint64, nLevels)
+	for _, levels := range data {
+		top := levels[0][0]
+		for l := 1; l < len(levels); l++ {
+			if len(levels[l]) == 0 || top.ImageWidth*top.ImageLength <= largest[l] {
+				continue
+			}
+			ovr := levels[l][0]
+			largest[l] = top.ImageWidth * top.ImageLength
+			factors[l] = math.Max(float64(top.ImageWidth)/float64(ovr.ImageWidth),
+				float64(top.ImageLength)/float64(ovr.ImageLength))
+			k := math.Round(factors[l])
+			if k >= 1 && uint64(math.Ceil(float64(top.ImageWidth)/k)) == ovr.ImageWidth &&
+				uint64(math.Ceil(float64(top.ImageLength)/k)) == ovr.ImageLength {
+				factors[l] = k
+			}
+		}
+	}
+	return factors
+}
+
+// shardIFD returns the top level IFD of an image holding the selected levels of the image,
+// factor being the zoom factor of the first selected level
+func shardIFD(top *IFD, levels [][]*IFD, selected []int, factor float64) *IFD {
+	copyIFD := func(ifd *IFD) *IFD {
+		c := *ifd
+		c.SubIFDs = nil
+		c.SubIFDOffsets = nil
+		return &c
+	}
+	first := levels[selected[0]]
+	mifd := copyIFD(first[0])
+	if selected[0] > 0 {
+		mifd.promote(top, factor)
+	}
+	for _, ifd := range first[1:] {
+		sifd := copyIFD(ifd)
+		// masks of the top level IFD are not reduced images
+		sifd.SubfileType &^= SubfileTypeReducedImage
+		mifd.SubIFDs = append(mifd.SubIFDs, sifd)
+	}
+	for _, l := range selected[1:] {
+		for _, ifd := range levels[l] {
+			mifd.SubIFDs = append(mifd.SubIFDs, copyIFD(ifd))
+		}
+	}
+	return mifd
+}
+
+// promote turns the overview ifd into a top level IFD, georeferenced from its full resolution top
+// whose pixels are factor times smaller
+func (ifd *IFD) promote(top *IFD, factor float64) {
+	ifd.SubfileType &^= SubfileTypeReducedImage
+	ifd.GeoKeyDirectoryTag = top.GeoKeyDirectoryTag
+	ifd.GeoDoubleParamsTag = top.GeoDoubleParamsTag
+	ifd.GeoAsciiParamsTag = top.GeoAsciiParamsTag
+	ifd.GDALMetaData = top.GDALMetaData
+	// top.gt is not the georeferencing of top in pixel space
+	if gt, err := top.geotransform(); err == nil {
+		gt[1], gt[2], gt[4], gt[5] = gt[1]*factor, gt[2]*factor, gt[4]*factor, gt[5]*factor
+		ifd.setGeoreferencing(gt)
+	}
+	ifd.RPCs = scaleRPCs(top.RPCs, factor, factor)
+	if ifd.DocumentName == "" {
+		ifd.DocumentName = top.DocumentName
+	}
+	if ifd.DateTime == "" {
+		ifd.DateTime = top.DateTime
+	}
+}
+
+// parseShard parses the selection of images and levels of a shard
+func parseShard(sel string, nImages, nLevels int) (images, levels []int, err error) {
+	images, levels = parseValues(":", nImages), parseValues(":", nLevels)
+	if sel == "" {
+		return images, levels, nil
+	}
+	defined := map[string]bool{}
+	for _, key := range strings.Split(sel, ">") {
+		kv := strings.SplitN(key, "=", 2)
+		if defined[kv[0]] {
+			return nil, nil, fmt.Errorf("%s is defined twice", kv[0])
+		}
+		defined[kv[0]] = true
+		if len(kv) == 1 {
+			continue
+		}
+		switch kv[0] {
+		case KEY_IMAGE:
+			images = parseValues(kv[1], nImages)
+		case KEY_LEVEL:
+			levels = parseValues(kv[1], nLevels)
+		default:
+			return nil, nil, fmt.Errorf("unknown key %s: must be one of [%s, %s]", kv[0], KEY_IMAGE, KEY_LEVEL)
+		}
+		if images == nil || levels == nil {
+			return nil, nil, fmt.Errorf("cannot parse %s", key)
+		}
+	}
+	return images, levels, nil
+}
+
+// parseValues parses a range (a:b) or a list of values (a,b,c) of integers in [0, max), and
+// returns them sorted. It returns nil if s cannot be parsed.
+func parseValues(s string, max int) []int {
+	values := []int{}
+	if strings.Contains(s, ":") {
+		bounds := strings.SplitN(s, ":", 2)
+		start, end := 0, max
+		var err error
+		if bounds[0] != "" {
+			if start, err = strconv.Atoi(bounds[0]); err != nil {
+				return nil
+			}
+		}
+		if bounds[1] != "" {
+			if end, err = strconv.Atoi(bounds[1]); err != nil {
+				return nil
+			}
+		}
+		for v := start; v < end && v < max; v++ {
+			if v >= 0 {
+				values = append(values, v)
+			}
+		}
+		return values
+	}
+	seen := map[int]bool{}
+	for _, vs := range strings.Split(s, ",") {
+		v, err := strconv.Atoi(vs)
+		if err != nil {
+			return nil
+		}
+		if v >= 0 && v < max && !seen[v] {
+			seen[v] = true
+			values = append(values, v)
+		}
+	}
+	sort.Ints(values)
+	return values
+}
@@ -0,0 +1,132 @@
+package mucog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/google/tiff"
+)
+
+// testShardedCOG returns a mucog of three 32x32 images with a 16x16 overview. Tiles are 4 bytes
+// long, tile t of image i is filled with 10*i+t, and the overview of image i with 10*i+9.
+func testShardedCOG(opts ...Option) *MultiCOG {
+	data := make([]byte, 3*20)
+	for i := range data {
+		image, t := i/20, (i%20)/4
+		if t == 4 {
+			t = 9
+		}
+		data[i] = byte(10*image + t)
+	}
+	src := bytes.NewReader(data)
+	r := tiff.NewBReader(src, binary.LittleEndian)
+	cog := New(opts...)
+	for i := 0; i < 3; i++ {
+		ifd := testLayoutIFD(0, 2, 4)
+		ifd.ImageLength = 32
+		ifd.OriginalTileOffsets = []uint64{0, 4, 8, 12}
+		ifd.TileByteCounts = []uint32{4, 4, 4, 4}
+		ovr := testLayoutIFD(0, 1, 4)
+		ovr.OriginalTileOffsets[0] = 16
+		ifd.AddOverview(ovr)
+		for _, f := range []*IFD{ifd, ovr} {
+			f.r = r
+			for t := range f.OriginalTileOffsets {
+				f.OriginalTileOffsets[t] += uint64(20 * i)
+			}
+		}
+		cog.AppendIFD(ifd)
+	}
+	return cog
+}
+
+func readTile(t *testing.T, ifd *IFD, idx int) []byte {
+	buf := make([]byte, ifd.TileByteCounts[idx])
+	if _, err := ifd.r.ReadAt(buf, int64(ifd.OriginalTileOffsets[idx])); err != nil {
+		t.Fatal(err)
+	}
+	return buf
+}
+
+func TestShards(t *testing.T) {
+	shards, err := testShardedCOG().Shards("I=0,1>L=0;I=2;L=1:>I=0:2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shards) != 3 {
+		t.Fatalf("got %d shards", len(shards))
+	}
+
+	_, ifds := writeAndLoad(t, shards[0].MultiCOG, false)
+	if len(ifds) != 2 || len(ifds[0].SubIFDs) != 0 || ifds[0].ImageWidth != 32 {
+		t.Errorf("full resolution shard: %d ifds", len(ifds))
+	}
+	if !bytes.Equal(readTile(t, ifds[1], 3), []byte{13, 13, 13, 13}) {
+		t.Errorf("full resolution shard: tile %v", readTile(t, ifds[1], 3))
+	}
+
+	_, ifds = writeAndLoad(t, shards[1].MultiCOG, false)
+	if len(ifds) != 1 || len(ifds[0].SubIFDs) != 1 {
+		t.Fatalf("image shard: %d ifds", len(ifds))
+	}
+	if !bytes.Equal(readTile(t, ifds[0].SubIFDs[0], 0), []byte{29, 29, 29, 29}) {
+		t.Errorf("image shard: overview tile %v", readTile(t, ifds[0].SubIFDs[0], 0))
+	}
+
+	_, ifds = writeAndLoad(t, shards[2].MultiCOG, true)
+	if len(ifds) != 2 {
+		t.Fatalf("overview shard: %d ifds", len(ifds))
+	}
+	for i, ifd := range ifds {
+		if ifd.SubfileType != SubfileTypeImage || ifd.ImageWidth != 16 || len(ifd.SubIFDs) != 0 {
+			t.Errorf("overview shard ifd %d: %+v", i, ifd)
+		}
+		gt, err := ifd.geotransform()
+		if err != nil || gt != (geotransform{0, 2, 0, 0, 0, -2}) {
+			t.Errorf("overview shard ifd %d: geotransform %v, %v", i, gt, err)
+		}
+		if tile := readTile(t, ifd, 0); tile[0] != byte(10*i+9) {
+			t.Errorf("overview shard ifd %d: tile %v", i, tile)
+		}
+	}
+	if shards[2].Images[1] != 1 || shards[2].Levels[0] != 1 {
+		t.Errorf("overview shard: images %v, levels %v", shards[2].Images, shards[2].Levels)
+	}
+
+	for _, spec := range []string{"L=0;I=0", "X=0", "I=a", "I=5"} {
+		if _, err := testShardedCOG().Shards(spec); err == nil {
+			t.Errorf("%s: expected error", spec)
+		}
+	}
+}
+
+func TestShardsOddSizes(t *testing.T) {
+	r := tiff.NewBReader(bytes.NewReader(make([]byte, 4)), binary.LittleEndian)
+	newIFD := func(ox float64, size uint64) *IFD {
+		n := int((size + 15) / 16)
+		ifd := testLayoutIFD(ox, n*n, 4)
+		ifd.ImageWidth, ifd.ImageLength = size, size
+		ifd.r = r
+		return ifd
+	}
+	cog := New()
+	// the 17x17 overview of the 33x33 image is not exactly half as large
+	for i, size := range []uint64{32, 33} {
+		ifd := newIFD(float64(64*i), size)
+		ifd.AddOverview(newIFD(0, (size+1)/2))
+		cog.AppendIFD(ifd)
+	}
+	shards, err := cog.Shards("L=0;L=1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeAndLoad(t, shards[0].MultiCOG, false)
+	_, ifds := writeAndLoad(t, shards[1].MultiCOG, false)
+	for i, ifd := range ifds {
+		gt, err := ifd.geotransform()
+		if err != nil || gt != (geotransform{float64(64 * i), 2, 0, 0, 0, -2}) {
+			t.Errorf("overview shard ifd %d: geotransform %v, %v", i, gt, err)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package mucog
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// SourceOrder writes the tiles in the order they are found in the sources (by source, then by
+// offset) instead of the order of the interlacing pattern, which then only selects the tiles.
+func SourceOrder() Option {
+	return func(cog *MultiCOG) {
+		cog.sourceOrder = true
+	}
+}
+
+// WriteSidecar writes the mucog as two files: hdr holds the tiff header, the IFDs and the strile
+// arrays, and data holds the tiles only, in the order defined by pattern.
+//
+// hdr is a tiff whose TileOffsets are offsets in data: a tile is read from data at its TileOffset.
+// The strile arrays are sized for the offsets shifted by the size of hdr, so that JoinSidecar
+// reassembles the single file mucog by patching the offsets. With Alignment, hdr is padded to a
+// multiple of the boundary, so that the tiles are aligned both in data and in the joined mucog.
+func (cog *MultiCOG) WriteSidecar(hdr, data io.Writer, bigtiff bool, pattern string) error {
+	cog.sidecar = true
+	defer func() { cog.sidecar = false }()
+	if err := cog.prepare(bigtiff, pattern); err != nil {
+		return err
+	}
+	if err := cog.writeHeaders(hdr, bigtiff); err != nil {
+		return err
+	}
+	if _, err := hdr.Write(make([]byte, cog.sidecarShift()-cog.plan.dataOffset)); err != nil {
+		return fmt.Errorf("write header padding: %w", err)
+	}
+	return cog.copyTiles(data)
+}
+
+// sidecarShift returns the size of the header written in sidecar mode, padded to the alignment
+// boundary
+func (cog *MultiCOG) sidecarShift() uint64 {
+	if cog.alignment > 1 {
+		return (cog.plan.dataOffset + cog.alignment - 1) / cog.alignment * cog.alignment
+	}
+	return cog.plan.dataOffset
+}
+
+// JoinSidecar writes to out the single file mucog made of the header hdr and the data file data
+// written by WriteSidecar: the header, with its tile offsets shifted by its size, followed by the
+// data file. The layout chosen when writing the pair (tile order, alignment, ghost area) is kept
+// as is, and the images are not validated again.
+func JoinSidecar(out io.Writer, hdr, data io.Reader) error {
+	buf, err := io.ReadAll(hdr)
+	if err != nil {
+		return fmt.Errorf("read header: %w", err)
+	}
+	if err := shiftTileOffsets(buf, uint64(len(buf))); err != nil {
+		return fmt.Errorf("header: %w", err)
+	}
+	if _, err := out.Write(buf); err != nil {
+		return fmt.Errorf("write header: %w", err)
+	}
+	if _, err := io.Copy(out, data); err != nil {
+		return fmt.Errorf("copy data: %w", err)
+	}
+	return nil
+}
+
+// shiftTileOffsets adds shift to the TileOffsets of all the IFDs and SubIFDs of the tiff file buf
+func shiftTileOffsets(buf []byte, shift uint64) error {
+	if len(buf) < 8 {
+		return fmt.Errorf("not a tiff file")
+	}
+	var enc binary.ByteOrder
+	switch string(buf[:2]) {
+	case "II":
+		enc = binary.LittleEndian
+	case "MM":
+		enc = binary.BigEndian
+	default:
+		return fmt.Errorf("not a tiff file")
+	}
+	bigtiff := enc.Uint16(buf[2:]) == 43
+	// size of an offset, of the number of entries, and of an entry
+	offSize, countSize, entrySize := uint64(4), uint64(2), uint64(12)
+	if bigtiff {
+		offSize, countSize, entrySize = 8, 8, 20
+	}
+	get := func(off, size uint64) (uint64, error) {
+		if off+size > uint64(len(buf)) {
+			return 0, fmt.Errorf("offset %d out of header", off)
+		}
+		switch size {
+		case 2:
+			return uint64(enc.Uint16(buf[off:])), nil
+		case 4:
+			return uint64(enc.Uint32(buf[off:])), nil
+		default:
+			return enc.Uint64(buf[off:]), nil
+		}
+	}
+	typeSizes := map[uint16]uint64{TLong: 4, 13: 4, TLong8: 8, TIFD8: 8} //13 is IFD
+
+	// the first IFD offset is at 4 in classic tiffs, and 8 in bigtiffs
+	first, err := get(offSize, offSize)
+	if err != nil {
+		return err
+	}
+	ifds := []uint64{first}
+	visited := map[uint64]bool{}
+	for len(ifds) > 0 {
+		off := ifds[0]
+		ifds = ifds[1:]
+		if off == 0 || visited[off] {
+			continue
+		}
+		visited[off] = true
+		n, err := get(off, countSize)
+		if err != nil {
+			return err
+		}
+		for e := uint64(0); e < n; e++ {
+			entry := off + countSize + e*entrySize
+			tag, err := get(entry, 2)
+			if err != nil {
+				return err
+			}
+			if tag != 324 && tag != 330 { //TileOffsets, SubIFDs
+				continue
+			}
+			typ, err := get(entry+2, 2)
+			if err != nil {
+				return err
+			}
+			count, err := get(entry+4, offSize)
+			if err != nil {
+				return err
+			}
+			size, ok := typeSizes[uint16(typ)]
+			if !ok {
+				return fmt.Errorf("tag %d has unexpected type %d", tag, typ)
+			}
+			values := entry + 4 + offSize
+			if count*size > offSize {
+				if values, err = get(values, offSize); err != nil {
+					return err
+				}
+			}
+			for i := uint64(0); i < count; i++ {
+				pos := values + i*size
+				v, err := get(pos, size)
+				if err != nil {
+					return err
+				}
+				if tag == 330 {
+					ifds = append(ifds, v)
+					continue
+				}
+				v += shift
+				if size == 8 {
+					enc.PutUint64(buf[pos:], v)
+				} else if v > uint64(^uint32(0)) {
+					return fmt.Errorf("tile offset %d overflows a LONG", v)
+				} else {
+					enc.PutUint32(buf[pos:], uint32(v))
+				}
+			}
+		}
+		next, err := get(off+countSize+n*entrySize, offSize)
+		if err != nil {
+			return err
+		}
+		ifds = append(ifds, next)
+	}
+	return nil
+}
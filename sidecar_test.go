@@ -0,0 +1,110 @@
+package mucog
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/tiff"
+)
+
+func TestSidecar(t *testing.T) {
+	for _, bigtiff := range []bool{false, true} {
+		for _, pattern := range []string{MUCOGPattern, "I>L>T>P"} {
+			expected := &bytes.Buffer{}
+			if err := testShardedCOG().Write(expected, bigtiff, pattern); err != nil {
+				t.Fatal(err)
+			}
+			hdr, data := &bytes.Buffer{}, &bytes.Buffer{}
+			if err := testShardedCOG().WriteSidecar(hdr, data, bigtiff, pattern); err != nil {
+				t.Fatal(err)
+			}
+			if hdr.Len()+data.Len() != expected.Len() {
+				t.Errorf("%s: sizes %d+%d, expected %d", pattern, hdr.Len(), data.Len(), expected.Len())
+			}
+
+			// tile offsets of the header point into the data file
+			tif, err := tiff.Parse(bytes.NewReader(hdr.Bytes()), nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ifds, err := LoadTIFF(tif, LoadTileData(bytes.NewReader(data.Bytes())))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, ifd := range ifds {
+				if tile := readTile(t, ifd, 3); tile[0] != byte(10*i+3) {
+					t.Errorf("%s: image %d tile %v", pattern, i, tile)
+				}
+				if tile := readTile(t, ifd.SubIFDs[0], 0); tile[0] != byte(10*i+9) {
+					t.Errorf("%s: image %d overview tile %v", pattern, i, tile)
+				}
+			}
+
+			joined := &bytes.Buffer{}
+			if err := JoinSidecar(joined, bytes.NewReader(hdr.Bytes()), bytes.NewReader(data.Bytes())); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(joined.Bytes(), expected.Bytes()) {
+				t.Errorf("%s: joined mucog differs from Write", pattern)
+			}
+		}
+	}
+}
+
+func TestJoinSidecarLayouts(t *testing.T) {
+	for name, opts := range map[string][]Option{
+		"pixel space": {PixelSpace()},
+		"aligned":     {Alignment(64, 0)},
+		"ghost":       {Alignment(64, 0), GhostArea()},
+	} {
+		for _, bigtiff := range []bool{false, true} {
+			hdr, data := &bytes.Buffer{}, &bytes.Buffer{}
+			if err := testShardedCOG(opts...).WriteSidecar(hdr, data, bigtiff, MUCOGPattern); err != nil {
+				t.Fatal(err)
+			}
+			joined := &bytes.Buffer{}
+			if err := JoinSidecar(joined, bytes.NewReader(hdr.Bytes()), bytes.NewReader(data.Bytes())); err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			if name == "pixel space" {
+				expected := &bytes.Buffer{}
+				if err := testShardedCOG(opts...).Write(expected, bigtiff, MUCOGPattern); err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(joined.Bytes(), expected.Bytes()) {
+					t.Errorf("%s: joined mucog differs from Write", name)
+				}
+			}
+			if name == "ghost" && !bytes.Contains(joined.Bytes(), []byte("BLOCK_LEADER=SIZE_AS_UINT4")) {
+				t.Errorf("%s: ghost area was lost", name)
+			}
+
+			tif, err := tiff.Parse(bytes.NewReader(joined.Bytes()), nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ifds, err := LoadTIFF(tif)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, ifd := range ifds {
+				if tile := readTile(t, ifd, 3); tile[0] != byte(10*i+3) {
+					t.Errorf("%s: image %d tile %v", name, i, tile)
+				}
+				if tile := readTile(t, ifd.SubIFDs[0], 0); tile[0] != byte(10*i+9) {
+					t.Errorf("%s: image %d overview tile %v", name, i, tile)
+				}
+				if name == "pixel space" {
+					continue
+				}
+				for _, f := range []*IFD{ifd, ifd.SubIFDs[0]} {
+					for _, off := range f.OriginalTileOffsets {
+						if off%64 != 0 {
+							t.Errorf("%s: image %d tile offset %d is not aligned", name, i, off)
+						}
+					}
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package mucog
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/tiff"
+)
+
+// Tag is a raw TIFF tag that is not explicitly handled by IFD. It is re-emitted verbatim
+// in the output file.
+type Tag struct {
+	ID    uint16
+	Type  uint16
+	Count uint64
+	Data  []byte           // raw values
+	Order binary.ByteOrder // byte order of Data
+}
+
+// knownTags are the tags explicitly handled by IFD
+var knownTags = func() map[uint16]bool {
+	known := map[uint16]bool{}
+	t := reflect.TypeOf(IFD{})
+	for i := 0; i < t.NumField(); i++ {
+		for _, opt := range strings.Split(t.Field(i).Tag.Get("tiff"), ",") {
+			if strings.HasPrefix(opt, "tag=") {
+				id, err := strconv.ParseUint(strings.TrimPrefix(opt, "tag="), 10, 16)
+				if err != nil {
+					panic(err)
+				}
+				known[uint16(id)] = true
+			}
+		}
+	}
+	return known
+}()
+
+// offsetTags are tags whose values are offsets in the source file, that would be invalid
+// once copied to the output file
+var offsetTags = map[uint16]bool{
+	273:   true, //StripOffsets
+	279:   true, //StripByteCounts
+	288:   true, //FreeOffsets
+	289:   true, //FreeByteCounts
+	513:   true, //JPEGInterchangeFormat
+	514:   true, //JPEGInterchangeFormatLength
+	34665: true, //ExifIFD
+	34853: true, //GPSIFD
+	40965: true, //InteroperabilityIFD
+}
+
+// tagTypeSize returns the size in bytes of a value of type typ, and the size of the
+// units to byte-swap when changing the byte order. It returns 0 for unknown types.
+func tagTypeSize(typ uint16) (size, unit uint64) {
+	switch typ {
+	case TByte, TAscii, TSByte, TUndefined:
+		return 1, 1
+	case TShort, TSShort:
+		return 2, 2
+	case TLong, TSLong, TFloat, 13: //13 is IFD
+		return 4, 4
+	case TRational, TSRational:
+		return 8, 4
+	case TDouble, TLong8, TSLong8, TIFD8:
+		return 8, 8
+	default:
+		return 0, 0
+	}
+}
+
+// loadTags returns the tags of tifd that are not handled by IFD, and that can be copied to another file
+func loadTags(tifd tiff.IFD) []Tag {
+	var tags []Tag
+	for _, f := range tifd.Fields() {
+		id := f.Tag().ID()
+		typ := f.Type().ID()
+		if knownTags[id] || offsetTags[id] || typ == 13 || typ == TIFD8 {
+			continue
+		}
+		size, _ := tagTypeSize(typ)
+		if size == 0 {
+			continue
+		}
+		data := f.Value().Bytes()
+		if uint64(len(data)) > size*f.Count() {
+			//inlined values are padded to the size of the entry's value field
+			data = data[:size*f.Count()]
+		}
+		tags = append(tags, Tag{
+			ID:    id,
+			Type:  typ,
+			Count: f.Count(),
+			Data:  append([]byte{}, data...),
+			Order: f.Value().Order(),
+		})
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i].ID < tags[j].ID })
+	return tags
+}
+
+// KeepTags restricts the extra tags (i.e. not explicitly handled by IFD) written to the output
+// to the given list. By default, all extra tags are kept.
+func KeepTags(ids ...uint16) Option {
+	return func(cog *MultiCOG) {
+		cog.keepTags = map[uint16]bool{}
+		for _, id := range ids {
+			cog.keepTags[id] = true
+		}
+	}
+}
+
+// DropTags removes the given extra tags (i.e. not explicitly handled by IFD) from the output
+func DropTags(ids ...uint16) Option {
+	return func(cog *MultiCOG) {
+		if cog.dropTags == nil {
+			cog.dropTags = map[uint16]bool{}
+		}
+		for _, id := range ids {
+			cog.dropTags[id] = true
+		}
+	}
+}
+
+// filterTags returns the extra tags of ifd that must be written, sorted by tag number
+func (cog *MultiCOG) filterTags(ifd *IFD) []Tag {
+	var tags []Tag
+	for _, tag := range ifd.ExtraTags {
+		if knownTags[tag.ID] || cog.dropTags[tag.ID] ||
+			(cog.keepTags != nil && !cog.keepTags[tag.ID]) {
+			continue
+		}
+		tags = append(tags, tag)
+	}
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].ID < tags[j].ID })
+	return tags
+}
+
+func (cog *MultiCOG) writeTag(w io.Writer, bigtiff bool, tag Tag, tags *TagData) error {
+	size, unit := tagTypeSize(tag.Type)
+	if size == 0 {
+		return fmt.Errorf("tag %d: unsupported type %d", tag.ID, tag.Type)
+	}
+	if uint64(len(tag.Data)) != size*tag.Count {
+		return fmt.Errorf("tag %d: inconsistent data size %d for %d values of type %d", tag.ID, len(tag.Data), tag.Count, tag.Type)
+	}
+	if !bigtiff && (tag.Type == TLong8 || tag.Type == TSLong8) {
+		return fmt.Errorf("tag %d: type %d requires bigtiff", tag.ID, tag.Type)
+	}
+	data := tag.Data
+	if unit > 1 && tag.Order != nil && normalizeByteOrder(tag.Order) != cog.enc {
+		data = append([]byte{}, data...)
+		swapBytes(data, int(unit))
+	}
+	var buf []byte
+	if bigtiff {
+		buf = make([]byte, 20)
+		cog.enc.PutUint64(buf[4:12], tag.Count)
+		if len(data) <= 8 {
+			copy(buf[12:], data)
+		} else {
+			cog.enc.PutUint64(buf[12:], tags.NextOffset())
+			tags.Write(data)
+		}
+	} else {
+		buf = make([]byte, 12)
+		cog.enc.PutUint32(buf[4:8], uint32(tag.Count))
+		if len(data) <= 4 {
+			copy(buf[8:], data)
+		} else {
+			cog.enc.PutUint32(buf[8:], uint32(tags.NextOffset()))
+			tags.Write(data)
+		}
+	}
+	cog.enc.PutUint16(buf[0:2], tag.ID)
+	cog.enc.PutUint16(buf[2:4], tag.Type)
+	_, err := w.Write(buf)
+	return err
+}
+
+// sortEntries sorts in place the fixed size IFD entries contained in buf by tag number
+func (cog *MultiCOG) sortEntries(buf []byte, bigtiff bool) {
+	size := 12
+	if bigtiff {
+		size = 20
+	}
+	entries := make([][]byte, len(buf)/size)
+	for i := range entries {
+		entries[i] = append([]byte{}, buf[i*size:(i+1)*size]...)
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return cog.enc.Uint16(entries[i]) < cog.enc.Uint16(entries[j])
+	})
+	for i, e := range entries {
+		copy(buf[i*size:], e)
+	}
+}
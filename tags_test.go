@@ -0,0 +1,141 @@
+package mucog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math/big"
+	"testing"
+
+	"github.com/google/tiff"
+)
+
+func writeAndLoad(t *testing.T, cog *MultiCOG, bigtiff bool) (tiff.TIFF, []*IFD) {
+	f := testTempFile(t, "mucog.tif")
+	if err := cog.Write(f, bigtiff, MUCOGPattern); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	tif, err := tiff.Parse(f, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ifds, err := LoadTIFF(tif)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tif, ifds
+}
+
+func testExtraTags() []Tag {
+	return []Tag{
+		{ID: 65000, Type: TShort, Count: 3, Data: []byte{0, 1, 0, 2, 0, 3}, Order: binary.BigEndian},
+		{ID: 270, Type: TAscii, Count: 12, Data: []byte("description\x00"), Order: binary.LittleEndian},
+		{ID: 282, Type: TRational, Count: 1, Data: []byte{0, 0, 0, 72, 0, 0, 0, 1}, Order: binary.BigEndian},
+	}
+}
+
+func TestExtraTags(t *testing.T) {
+	for _, bigtiff := range []bool{false, true} {
+		ifd := testUInt16IFD(binary.LittleEndian, make([]uint16, 16*16))
+		ifd.ExtraTags = testExtraTags()
+		cog := New()
+		cog.AppendIFD(ifd)
+		tif, ifds := writeAndLoad(t, cog, bigtiff)
+
+		prev := uint16(0)
+		for _, f := range tif.IFDs()[0].Fields() {
+			if f.Tag().ID() <= prev {
+				t.Errorf("tag %d written after %d", f.Tag().ID(), prev)
+			}
+			prev = f.Tag().ID()
+		}
+
+		got := ifds[0].ExtraTags
+		if len(got) != 3 || got[0].ID != 270 || got[1].ID != 282 || got[2].ID != 65000 {
+			t.Fatalf("unexpected tags %+v", got)
+		}
+		if string(got[0].Data) != "description\x00" {
+			t.Errorf("description: %q", got[0].Data)
+		}
+		if got[1].Type != TRational || got[1].Order.Uint32(got[1].Data) != 72 || got[1].Order.Uint32(got[1].Data[4:]) != 1 {
+			t.Errorf("xresolution: %+v", got[1])
+		}
+		if !bytes.Equal(got[2].Data, []byte{1, 0, 2, 0, 3, 0}) {
+			t.Errorf("custom tag: %v", got[2].Data)
+		}
+	}
+}
+
+func TestExtraTagsFilter(t *testing.T) {
+	for _, tc := range []struct {
+		opts     []Option
+		expected []uint16
+	}{
+		{[]Option{KeepTags(282)}, []uint16{282}},
+		{[]Option{DropTags(282)}, []uint16{270, 65000}},
+		{[]Option{KeepTags(270, 282), DropTags(282)}, []uint16{270}},
+	} {
+		ifd := testUInt16IFD(binary.LittleEndian, make([]uint16, 16*16))
+		ifd.ExtraTags = testExtraTags()
+		cog := New(tc.opts...)
+		cog.AppendIFD(ifd)
+		_, ifds := writeAndLoad(t, cog, false)
+		got := ifds[0].ExtraTags
+		if len(got) != len(tc.expected) {
+			t.Errorf("got %d tags, expected %v", len(got), tc.expected)
+			continue
+		}
+		for i := range got {
+			if got[i].ID != tc.expected[i] {
+				t.Errorf("tag %d: got %d, expected %d", i, got[i].ID, tc.expected[i])
+			}
+		}
+	}
+}
+
+func TestYCbCrTags(t *testing.T) {
+	for _, bigtiff := range []bool{false, true} {
+		ifd := testLayoutIFD(0, 1, 16)
+		ifd.r = tiff.NewBReader(bytes.NewReader(make([]byte, 16)), binary.LittleEndian)
+		ifd.BitsPerSample = []uint16{8, 8, 8}
+		ifd.SamplesPerPixel = 3
+		ifd.Compression = 7
+		ifd.PhotometricInterpretation = PhotometricInterpretationYCbCr
+		ifd.YCbCrSubsampling = []uint16{2, 2}
+		ifd.YCbCrPositioning = 1
+		ifd.ReferenceBlackWhite = []*big.Rat{
+			big.NewRat(0, 1), big.NewRat(255, 1), big.NewRat(128, 1),
+			big.NewRat(255, 1), big.NewRat(128, 1), big.NewRat(255, 2),
+		}
+		cog := New()
+		cog.AppendIFD(ifd)
+		_, ifds := writeAndLoad(t, cog, bigtiff)
+		got := ifds[0]
+		if len(got.ExtraTags) != 0 {
+			t.Errorf("unexpected extra tags %+v", got.ExtraTags)
+		}
+		if len(got.YCbCrSubsampling) != 2 || got.YCbCrSubsampling[0] != 2 || got.YCbCrSubsampling[1] != 2 {
+			t.Errorf("YCbCrSubsampling: %v", got.YCbCrSubsampling)
+		}
+		if got.YCbCrPositioning != 1 {
+			t.Errorf("YCbCrPositioning: %d", got.YCbCrPositioning)
+		}
+		if len(got.ReferenceBlackWhite) != len(ifd.ReferenceBlackWhite) {
+			t.Fatalf("ReferenceBlackWhite: %v", got.ReferenceBlackWhite)
+		}
+		for i, r := range got.ReferenceBlackWhite {
+			if r.Cmp(ifd.ReferenceBlackWhite[i]) != 0 {
+				t.Errorf("ReferenceBlackWhite[%d]: %s, expected %s", i, r, ifd.ReferenceBlackWhite[i])
+			}
+		}
+
+		// tag write errors are returned, not panicked
+		ifd.ReferenceBlackWhite[0] = big.NewRat(-1, 1)
+		if err := cog.Write(io.Discard, bigtiff, MUCOGPattern); err == nil {
+			t.Error("expected negative ReferenceBlackWhite to be rejected")
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package mucog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/google/tiff"
+)
+
+// testRectIFD returns a 2048x1024 image of 512x256 tiles at origin (ox, oy), with a 1024x512
+// overview. Tiles are 1 byte long.
+func testRectIFD(r tiff.BReader, ox, oy float64) *IFD {
+	newIFD := func(w, h uint64) *IFD {
+		ifd := testLayoutIFD(0, 1, 1)
+		ifd.r = r
+		ifd.ImageWidth, ifd.ImageLength = w, h
+		ifd.TileWidth, ifd.TileLength = 512, 256
+		ntiles := ((w + 511) / 512) * ((h + 255) / 256)
+		ifd.OriginalTileOffsets = make([]uint64, ntiles)
+		ifd.TileByteCounts = make([]uint32, ntiles)
+		for i := range ifd.TileByteCounts {
+			ifd.OriginalTileOffsets[i] = uint64(i)
+			ifd.TileByteCounts[i] = 1
+		}
+		return ifd
+	}
+	ifd := newIFD(2048, 1024)
+	ifd.ModelTiePointTag = []float64{0, 0, 0, ox, oy, 0}
+	ifd.AddOverview(newIFD(1024, 512))
+	return ifd
+}
+
+func TestRectangularTiles(t *testing.T) {
+	r := tiff.NewBReader(bytes.NewReader(make([]byte, 16)), binary.LittleEndian)
+	cog := New()
+	cog.AppendIFD(testRectIFD(r, 0, 0))
+	// two tiles right and two tiles down
+	cog.AppendIFD(testRectIFD(r, 1024, -512))
+	if err := cog.computeImageryOffsets(false, MUCOGPattern); err != nil {
+		t.Fatal(err)
+	}
+	for i, expected := range [][4]uint64{{0, 0, 4, 4}, {2, 2, 6, 6}} {
+		ifd := cog.ifds[i]
+		if got := [4]uint64{ifd.minx, ifd.miny, ifd.maxx, ifd.maxy}; got != expected {
+			t.Errorf("ifd %d: grid %v, expected %v", i, got, expected)
+		}
+	}
+	for i, expected := range [][4]uint64{{0, 0, 2, 2}, {1, 1, 3, 3}} {
+		ifd := cog.ifds[i].SubIFDs[0]
+		if got := [4]uint64{ifd.minx, ifd.miny, ifd.maxx, ifd.maxy}; got != expected {
+			t.Errorf("overview %d: grid %v, expected %v", i, got, expected)
+		}
+	}
+	if cog.plan.Len() != 2*(16+4) {
+		t.Errorf("%d tiles in plan", cog.plan.Len())
+	}
+
+	_, ifds := writeAndLoad(t, cog, false)
+	if len(ifds) != 2 || ifds[1].TileWidth != 512 || ifds[1].TileLength != 256 || ifds[1].SubIFDs[0].TileLength != 256 {
+		t.Errorf("unexpected loaded ifds %+v", ifds)
+	}
+
+	// alignment is checked against each tile dimension
+	cog = New()
+	cog.AppendIFD(testRectIFD(r, 0, 0))
+	cog.AppendIFD(testRectIFD(r, 256, 0))
+	if err := cog.computeImageryOffsets(false, MUCOGPattern); err == nil {
+		t.Error("expected grid alignment error")
+	}
+	cog = New()
+	cog.AppendIFD(testRectIFD(r, 0, 0))
+	cog.AppendIFD(testRectIFD(r, 512, -256))
+	if err := cog.computeImageryOffsets(false, MUCOGPattern); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,134 @@
+package mucog
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// VirtualFile is a mucog that is not materialized: it is composed of an in-memory header, and of
+// the byte ranges of the tiles in the source files. It implements io.ReaderAt and can be read
+// concurrently.
+type VirtualFile struct {
+	header []byte
+	plan   *tilePlan
+	size   int64
+}
+
+// VirtualFile returns the mucog that Write would produce, as a VirtualFile. The sources of the
+// MultiCOG must remain readable as long as the VirtualFile is used.
+func (cog *MultiCOG) VirtualFile(bigtiff bool, pattern string) (*VirtualFile, error) {
+	if err := cog.prepare(bigtiff, pattern); err != nil {
+		return nil, err
+	}
+	header := &bytes.Buffer{}
+	if err := cog.writeHeaders(header, bigtiff); err != nil {
+		return nil, err
+	}
+	return &VirtualFile{
+		header: header.Bytes(),
+		plan:   cog.plan,
+		size:   int64(cog.size(bigtiff)),
+	}, nil
+}
+
+// Size returns the size of the mucog
+func (vf *VirtualFile) Size() int64 {
+	return vf.size
+}
+
+// ReadAt implements io.ReaderAt
+func (vf *VirtualFile) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset %d", off)
+	}
+	n := 0
+	for n < len(p) {
+		pos := off + int64(n)
+		if pos >= vf.size {
+			return n, io.EOF
+		}
+		if pos < int64(len(vf.header)) {
+			n += copy(p[n:], vf.header[pos:])
+			continue
+		}
+		// last tile starting at or before pos
+		i := sort.Search(vf.plan.Len(), func(i int) bool {
+			return vf.plan.blockStart(i) > uint64(pos)
+		}) - 1
+		if i < 0 || uint64(pos) >= vf.plan.blockEnd(i) {
+			// unused space before the next tile
+			end := vf.size
+			if i+1 < vf.plan.Len() {
+				end = int64(vf.plan.blockStart(i + 1))
+			}
+			for ; n < len(p) && off+int64(n) < end; n++ {
+				p[n] = 0
+			}
+			continue
+		}
+		start, size := vf.plan.offset[i], uint64(vf.plan.size[i])
+		if uint64(pos) < start || uint64(pos) >= start+size {
+			read, err := vf.readFrame(p[n:], i, uint64(pos))
+			n += read
+			if err != nil {
+				return n, err
+			}
+			continue
+		}
+		read, err := vf.readTile(p[n:], i, uint64(pos)-start)
+		n += read
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// readFrame reads the leader or the trailer of tile i, starting at offset pos of the mucog
+func (vf *VirtualFile) readFrame(p []byte, i int, pos uint64) (int, error) {
+	size := vf.plan.size[i]
+	var buf [blockFrameSize]byte
+	tail := buf[:]
+	if size < blockFrameSize {
+		tail = tail[:size]
+	}
+	if _, err := vf.readTile(tail, i, uint64(size)-uint64(len(tail))); err != nil {
+		return 0, err
+	}
+	leader, trailer := blockFrame(size, tail)
+	if pos < vf.plan.offset[i] {
+		return copy(p, leader[pos-vf.plan.blockStart(i):]), nil
+	}
+	return copy(p, trailer[pos-vf.plan.offset[i]-uint64(size):]), nil
+}
+
+// readTile reads the tile i of the plan from its source, starting at off in the tile
+func (vf *VirtualFile) readTile(p []byte, i int, off uint64) (int, error) {
+	ifd, idx := vf.plan.IFD(i)
+	size := uint64(vf.plan.size[i])
+	if uint64(len(p)) > size-off {
+		p = p[:size-off]
+	}
+	src := int64(ifd.OriginalTileOffsets[idx])
+	if ifd.swapSize == 0 {
+		n, err := ifd.r.ReadAt(p, src+int64(off))
+		if n == len(p) {
+			err = nil
+		} else if err == nil || err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return n, err
+	}
+	// swapped samples must be read entirely
+	tile := make([]byte, size)
+	if n, err := ifd.r.ReadAt(tile, src); n < len(tile) {
+		if err == nil || err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, err
+	}
+	swapBytes(tile, ifd.swapSize)
+	return copy(p, tile[off:]), nil
+}
@@ -0,0 +1,83 @@
+package mucog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/google/tiff"
+)
+
+func TestVirtualFile(t *testing.T) {
+	for _, bigtiff := range []bool{false, true} {
+		expected := &bytes.Buffer{}
+		if err := testInterlacedCOG().Write(expected, bigtiff, MUCOGPattern); err != nil {
+			t.Fatal(err)
+		}
+		vf, err := testInterlacedCOG().VirtualFile(bigtiff, MUCOGPattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if vf.Size() != int64(expected.Len()) {
+			t.Fatalf("size %d, expected %d", vf.Size(), expected.Len())
+		}
+		got, err := io.ReadAll(io.NewSectionReader(vf, 0, vf.Size()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, expected.Bytes()) {
+			t.Error("virtual file content differs from Write")
+		}
+
+		rnd := rand.New(rand.NewSource(0))
+		for i := 0; i < 100; i++ {
+			off := rnd.Int63n(vf.Size())
+			buf := make([]byte, rnd.Intn(40)+1)
+			n, err := vf.ReadAt(buf, off)
+			if off+int64(len(buf)) > vf.Size() {
+				if err != io.EOF || n != int(vf.Size()-off) {
+					t.Errorf("ReadAt(%d, %d) past end: %d, %v", len(buf), off, n, err)
+				}
+			} else if err != nil || n != len(buf) {
+				t.Errorf("ReadAt(%d, %d): %d, %v", len(buf), off, n, err)
+			}
+			if !bytes.Equal(buf[:n], expected.Bytes()[off:off+int64(n)]) {
+				t.Errorf("ReadAt(%d, %d): content mismatch", len(buf), off)
+			}
+		}
+
+		tif, err := tiff.Parse(io.NewSectionReader(vf, 0, vf.Size()), nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ifds, err := LoadTIFF(tif); err != nil || len(ifds) != 2 {
+			t.Errorf("load virtual file: %d ifds, %v", len(ifds), err)
+		}
+	}
+}
+
+func TestVirtualFileSwap(t *testing.T) {
+	values := make([]uint16, 16*16)
+	for i := range values {
+		values[i] = uint16(i)
+	}
+	cog := New(ByteOrder(binary.BigEndian))
+	cog.AppendIFD(testUInt16IFD(binary.LittleEndian, values))
+	vf, err := cog.VirtualFile(false, MUCOGPattern)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// read a single, unaligned, byte of each sample
+	start := vf.Size() - int64(2*len(values))
+	for i, v := range values {
+		buf := make([]byte, 1)
+		if _, err := vf.ReadAt(buf, start+int64(2*i+1)); err != nil {
+			t.Fatal(err)
+		}
+		if buf[0] != byte(v) {
+			t.Fatalf("sample %d: got low byte %d, expected %d", i, buf[0], byte(v))
+		}
+	}
+}